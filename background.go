@@ -0,0 +1,118 @@
+package noteshrink
+
+import (
+	"fmt"
+	"sort"
+)
+
+//BackgroundMode は背景色の推定方法です
+type BackgroundMode int
+
+const (
+	//MostFrequent はサンプル中で最も多い色を背景色にします
+	MostFrequent BackgroundMode = iota
+	//BorderSampling は画像の外周部で最も多い色を背景色にします
+	//大きな図で紙面の大半が埋まっている場合に利用します
+	BorderSampling
+	//BrightestPercentile はサンプル中の明るい色の平均を背景色にします
+	//紙の色にムラがある場合に利用します
+	BrightestPercentile
+	//FixedBackground は Option.BackgroundColor を背景色にします
+	FixedBackground
+)
+
+func (m BackgroundMode) String() string {
+	switch m {
+	case MostFrequent:
+		return "MostFrequent"
+	case BorderSampling:
+		return "BorderSampling"
+	case BrightestPercentile:
+		return "BrightestPercentile"
+	case FixedBackground:
+		return "FixedBackground"
+	}
+	return fmt.Sprintf("BackgroundMode(%d)", int(m))
+}
+
+//外周部とする画像の割合
+const borderRate = 0.02
+
+//BackgroundPercentile を指定しない場合の割合
+const defaultPercentile = 0.1
+
+//背景色の推定に利用する画素
+//
+//BorderSampling の場合は画像の外周部、それ以外はサンプルを返します
+func backgroundPixels(data, samples *pixelBuffer, op *Option) *pixelBuffer {
+	if op.BackgroundMode == BorderSampling {
+		return borderPixels(data)
+	}
+	return samples
+}
+
+//画像の外周部の画素
+func borderPixels(p *pixelBuffer) *pixelBuffer {
+	rtn := newPixelBuffer(0, 1)
+	rtn.pix = appendBorder(rtn.pix, p, 0, p.rows)
+	rtn.cols = rtn.len()
+	return rtn
+}
+
+//画像の一部の行の外周部の画素を追加
+//
+//p は画像の y0 行目からの行で、rows は画像全体の行数です
+//幅は短辺の borderRate で最低1画素です
+func appendBorder(dst []uint32, p *pixelBuffer, y0, rows int) []uint32 {
+
+	w := p.cols
+	if rows < w {
+		w = rows
+	}
+	w = int(float64(w) * borderRate)
+	if w < 1 {
+		w = 1
+	}
+
+	for y := 0; y < p.rows; y++ {
+		row := y0 + y
+		for x := 0; x < p.cols; x++ {
+			if x < w || x >= p.cols-w || row < w || row >= rows-w {
+				dst = append(dst, p.pix[y*p.cols+x])
+			}
+		}
+	}
+	return dst
+}
+
+//明るい画素の平均色
+//
+//Vの大きい順に percent の割合の画素を平均します
+func brightestColor(p *pixelBuffer, percent float64) (*Pixel, error) {
+
+	if p.len() == 0 {
+		return nil, fmt.Errorf("pixels length zero")
+	}
+	if percent <= 0 {
+		percent = defaultPercentile
+	}
+	if percent > 1 {
+		return nil, fmt.Errorf("BackgroundPercentile must be 0-1[%f]", percent)
+	}
+
+	index := make([]int, p.len())
+	value := make([]float64, p.len())
+	for idx := range p.pix {
+		index[idx] = idx
+		_, _, value[idx] = p.hsv(idx)
+	}
+	sort.SliceStable(index, func(i, j int) bool {
+		return value[index[i]] > value[index[j]]
+	})
+
+	num := int(float64(p.len()) * percent)
+	if num < 1 {
+		num = 1
+	}
+	return p.subset(index[:num]).pixels().Average()
+}
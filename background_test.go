@@ -0,0 +1,177 @@
+package noteshrink
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestBorderSampling(t *testing.T) {
+
+	//紙面の大半を塗りつぶした図
+	paper := color.RGBA{R: 240, G: 240, B: 240, A: 255}
+	fill := color.RGBA{R: 40, G: 80, B: 200, A: 255}
+	img := createIllustration(100, 100, paper, fill)
+
+	data, err := convertPixels(img)
+	if err != nil {
+		t.Fatalf("convertPixels() Error[%v]", err)
+	}
+
+	op := DefaultOption()
+	bg, err := getBackgroundColor(backgroundPixels(data, data, op), op)
+	if err != nil {
+		t.Fatalf("getBackgroundColor() Error[%v]", err)
+	}
+	if bg.B != 200 {
+		t.Errorf("MostFrequent background [%v]", bg)
+	}
+
+	op.BackgroundMode = BorderSampling
+	bg, err = getBackgroundColor(backgroundPixels(data, data, op), op)
+	if err != nil {
+		t.Fatalf("getBackgroundColor() Error[%v]", err)
+	}
+	if bg.R != 240 || bg.G != 240 || bg.B != 240 {
+		t.Errorf("BorderSampling background [%v]", bg)
+	}
+
+	r, err := ShrinkResult(img, op)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+	if r.Background.R != 240 {
+		t.Errorf("ShrinkResult() BorderSampling background [%v]", r.Background)
+	}
+	if r.Labels[50*100+50] == 0 {
+		t.Errorf("ShrinkResult() BorderSampling illustration is background")
+	}
+
+	p, err := BuildPalette([]image.Image{img, img}, op)
+	if err != nil {
+		t.Fatalf("BuildPalette() Error[%v]", err)
+	}
+	if p.Background.R != 240 {
+		t.Errorf("BuildPalette() BorderSampling background [%v]", p.Background)
+	}
+}
+
+func TestBorderPixels(t *testing.T) {
+
+	tests := []struct {
+		cols, rows int
+		expected   int
+	}{
+		{100, 50, 2*100 + 2*48},
+		{200, 100, 200*100 - 196*96},
+		{1, 1, 1},
+		{3, 1, 3},
+	}
+
+	for _, test := range tests {
+		p := newPixelBuffer(test.cols, test.rows)
+		b := borderPixels(p)
+		if b.len() != test.expected {
+			t.Errorf("borderPixels() [%dx%d] [%d]!=[%d]", test.cols, test.rows, b.len(), test.expected)
+		}
+	}
+}
+
+func TestBrightestPercentile(t *testing.T) {
+
+	//左右で色の違う黄色い紙に、紙面の3割の黒いインク
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			c := color.RGBA{R: uint8(250 - x/5), G: uint8(240 - x/5), B: uint8(190 - x/5), A: 255}
+			if y < 30 {
+				c = color.RGBA{R: 30, G: 30, B: 30, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	data, err := convertPixels(img)
+	if err != nil {
+		t.Fatalf("convertPixels() Error[%v]", err)
+	}
+
+	op := DefaultOption()
+	bg, err := getBackgroundColor(data, op)
+	if err != nil {
+		t.Fatalf("getBackgroundColor() Error[%v]", err)
+	}
+	if bg.R != 28 {
+		t.Errorf("MostFrequent background [%v]", bg)
+	}
+
+	op.BackgroundMode = BrightestPercentile
+	bg, err = getBackgroundColor(data, op)
+	if err != nil {
+		t.Fatalf("getBackgroundColor() Error[%v]", err)
+	}
+	if bg.R < 245 || bg.G < 235 || bg.B < 185 {
+		t.Errorf("BrightestPercentile background [%v]", bg)
+	}
+
+	op.BackgroundPercentile = 0.7
+	bg, err = getBackgroundColor(data, op)
+	if err != nil {
+		t.Fatalf("getBackgroundColor() Error[%v]", err)
+	}
+	if bg.R < 235 || bg.R > 245 {
+		t.Errorf("BrightestPercentile 0.7 background [%v]", bg)
+	}
+
+	op.BackgroundPercentile = 2
+	_, err = getBackgroundColor(data, op)
+	if err == nil {
+		t.Errorf("BackgroundPercentile over 1 not error")
+	}
+}
+
+func TestFixedBackground(t *testing.T) {
+
+	img := createNote(100, 80, color.RGBA{R: 20, G: 40, B: 200, A: 255})
+
+	op := DefaultOption()
+	op.BackgroundMode = FixedBackground
+	_, err := ShrinkResult(img, op)
+	if err == nil {
+		t.Errorf("FixedBackground BackgroundColor nil not error")
+	}
+
+	op.BackgroundColor = NewPixelRGB(240, 240, 240)
+	r, err := ShrinkResult(img, op)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+	if r.Background.R != 240 || r.Background.G != 240 || r.Background.B != 240 {
+		t.Errorf("FixedBackground background [%v]", r.Background)
+	}
+	if r.Background == op.BackgroundColor {
+		t.Errorf("FixedBackground background is same pointer")
+	}
+
+	op.BackgroundMode = BackgroundMode(10)
+	_, err = ShrinkResult(img, op)
+	if err == nil {
+		t.Errorf("BackgroundMode unknown not error")
+	}
+}
+
+//Test用のツール
+//外周10%を残して指定色で塗りつぶした画像を作成
+func createIllustration(cols, rows int, paper, fill color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, cols, rows))
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			c := paper
+			if x >= cols/10 && x < cols*9/10 && y >= rows/10 && y < rows*9/10 {
+				c = fill
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
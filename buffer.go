@@ -0,0 +1,99 @@
+package noteshrink
+
+import (
+	"sync"
+)
+
+//pixelBuffer は画像の画素をRGBを詰めた値で保持します
+//
+//画素は行優先で並び、HSVは必要になった時点で計算します
+type pixelBuffer struct {
+	cols int
+	rows int
+	pix  []uint32
+}
+
+//バッファの作成
+func newPixelBuffer(cols, rows int) *pixelBuffer {
+	b := pixelBuffer{}
+	b.cols = cols
+	b.rows = rows
+	b.pix = make([]uint32, cols*rows)
+	return &b
+}
+
+//画素数
+func (b *pixelBuffer) len() int {
+	return len(b.pix)
+}
+
+//RGBを詰めた値
+func packRGB(r, g, b uint8) uint32 {
+	return uint32(r)<<16 | uint32(g)<<8 | uint32(b)
+}
+
+//詰めた値からRGBを取得
+func unpackRGB(v uint32) (uint8, uint8, uint8) {
+	return uint8(v >> 16), uint8(v >> 8), uint8(v)
+}
+
+//RGBを取得
+func (b *pixelBuffer) rgb(i int) (uint8, uint8, uint8) {
+	return unpackRGB(b.pix[i])
+}
+
+//HSVを計算
+func (b *pixelBuffer) hsv(i int) (float64, float64, float64) {
+	return RGB2HSV(b.rgb(i))
+}
+
+//Pixelの作成
+func (b *pixelBuffer) pixel(i int) *Pixel {
+	return NewPixelRGB(b.rgb(i))
+}
+
+//Pixelsへの変換
+//
+//デバッグ、テスト用で画素ごとにPixelを作成します
+func (b *pixelBuffer) pixels() Pixels {
+	rtn := make(Pixels, len(b.pix))
+	for idx := range b.pix {
+		rtn[idx] = b.pixel(idx)
+	}
+	return rtn
+}
+
+//指定位置の画素のみのバッファを作成
+func (b *pixelBuffer) subset(index []int) *pixelBuffer {
+	rtn := newPixelBuffer(len(index), 1)
+	for i, idx := range index {
+		rtn.pix[i] = b.pix[idx]
+	}
+	return rtn
+}
+
+//行を workers 個の帯に分けて並列に処理
+//
+//fn には帯の開始行と終了行(含まない)を渡します。workers が1以下の場合は呼び出し元で処理します
+func rowBands(rows, workers int, fn func(y0, y1 int)) {
+
+	if workers > rows {
+		workers = rows
+	}
+	if workers <= 1 {
+		fn(0, rows)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		y0 := rows * i / workers
+		y1 := rows * (i + 1) / workers
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(y0, y1)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,546 @@
+package noteshrink
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+)
+
+//Clusterer は前景色のサンプルから出力する色を選定します
+//
+//Option.Clusterer に設定することで選定方法を変更できます
+type Clusterer interface {
+	//Cluster はサンプルから最大k色を選定します
+	//乱数を利用する場合は rnd を利用してください
+	Cluster(samples Pixels, k int, rnd *rand.Rand) (Pixels, error)
+}
+
+//KMeans はkmeansによる選定です
+type KMeans struct {
+	//Iterate は最大のループ数です
+	Iterate int
+	//Space は距離を測る色空間です
+	Space ColorSpace
+}
+
+//オプションから選定方法を取得
+func (op *Option) clusterer() Clusterer {
+	if op.Clusterer != nil {
+		return op.Clusterer
+	}
+	return &KMeans{Iterate: op.Iterate, Space: op.ColorSpace}
+}
+
+//Clusterer の実装
+//
+//サンプルの色の種類がk未満の場合は、その色をすべて返します
+func (km *KMeans) Cluster(samples Pixels, k int, rnd *rand.Rand) (Pixels, error) {
+
+	if k < 1 {
+		return nil, fmt.Errorf("cluster num must be positive[%d]", k)
+	}
+	if rnd == nil {
+		return nil, fmt.Errorf("rand is nil")
+	}
+
+	p := newPixelBuffer(len(samples), 1)
+	for idx, pix := range samples {
+		p.pix[idx] = packRGB(pix.R, pix.G, pix.B)
+	}
+	return kmeans(p, k, km.Iterate, km.Space, rnd)
+}
+
+//Value は ValueKMeans で選定する値です
+//
+//独自の距離、平均を実装することで選定方法を変更できます
+type Value interface {
+	//Distance は値との距離です(初期値はこの値に比例した確率で選びます)
+	Distance(Value) float64
+	//Average は所属する値の平均です
+	//所属する値がない場合は自身を返してください
+	Average([]Value) (Value, error)
+}
+
+//ValueKMeans は Value によるkmeansの選定です
+//
+//NewValue,Pixel を指定しない場合はRGBの距離、平均で選定します
+type ValueKMeans struct {
+	//Iterate は最大のループ数です
+	Iterate int
+	//NewValue は色を Value に変換します
+	NewValue func(*Pixel) Value
+	//Pixel は選定した Value を色に戻します
+	Pixel func(Value) (*Pixel, error)
+}
+
+//Clusterer の実装
+//
+//サンプルの色の種類がk未満の場合は、その色をすべて返します
+func (km *ValueKMeans) Cluster(samples Pixels, k int, rnd *rand.Rand) (Pixels, error) {
+
+	if k < 1 {
+		return nil, fmt.Errorf("cluster num must be positive[%d]", k)
+	}
+	if rnd == nil {
+		return nil, fmt.Errorf("rand is nil")
+	}
+
+	newValue, toPixel := km.NewValue, km.Pixel
+	if newValue == nil && toPixel == nil {
+		newValue, toPixel = newRGBValue, rgbPixel
+	} else if newValue == nil || toPixel == nil {
+		return nil, fmt.Errorf("ValueKMeans requires both NewValue and Pixel")
+	}
+
+	//色の種類が少ない場合はそのまま利用
+	p := newPixelBuffer(len(samples), 1)
+	for idx, pix := range samples {
+		p.pix[idx] = packRGB(pix.R, pix.G, pix.B)
+	}
+	colors := distinctColors(p, k+1)
+	if len(colors) <= k {
+		labels := make([]*Pixel, len(colors))
+		for i, v := range colors {
+			labels[i] = NewPixelRGB(unpackRGB(v))
+		}
+		return labels, nil
+	}
+
+	data := make([]Value, len(samples))
+	for idx, pix := range samples {
+		data[idx] = newValue(pix)
+	}
+
+	values, err := kmeansValue(data, seedValues(data, k, rnd), km.Iterate)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make([]*Pixel, len(values))
+	for i, v := range values {
+		labels[i], err = toPixel(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return labels, nil
+}
+
+//Value のkmeans
+func kmeansValue(data []Value, labels []Value, itr int) ([]Value, error) {
+
+	index := make([]int, len(data))
+	for idx, datum := range data {
+		index[idx] = closestIndex(datum, labels)
+	}
+
+	rtn := make([]Value, len(labels))
+	for idx, label := range labels {
+		rtn[idx] = label
+	}
+
+	for idx := 0; idx < itr; idx++ {
+
+		groups := make([][]Value, len(rtn))
+		for i := range rtn {
+			groups[i] = make([]Value, 0, len(data))
+		}
+
+		for i, elm := range data {
+			idx := index[i]
+			groups[idx] = append(groups[idx], elm)
+		}
+
+		for i, label := range rtn {
+			ave, err := label.Average(groups[i])
+			if err != nil {
+				return nil, err
+			}
+			if ave != nil {
+				rtn[i] = ave
+			}
+		}
+
+		changes := 0
+		for i, pix := range data {
+			if newIdx := closestIndex(pix, rtn); newIdx != index[i] {
+				changes++
+				index[i] = newIdx
+			}
+		}
+
+		if changes == 0 {
+			break
+		}
+	}
+	return rtn, nil
+}
+
+//近い Value の位置を取得
+func closestIndex(val Value, labels []Value) int {
+	rtn := -1
+	min := math.MaxFloat64
+	for idx, elm := range labels {
+		vd := val.Distance(elm)
+		if vd < min {
+			min = vd
+			rtn = idx
+		}
+	}
+	return rtn
+}
+
+//k-means++ による Value の初期値の選定
+func seedValues(data []Value, k int, rnd *rand.Rand) []Value {
+
+	labels := make([]Value, 0, k)
+	labels = append(labels, data[rnd.Intn(len(data))])
+
+	dist := make([]float64, len(data))
+	for idx := range dist {
+		dist[idx] = math.MaxFloat64
+	}
+
+	for len(labels) < k {
+
+		last := labels[len(labels)-1]
+		total := 0.0
+		for idx, v := range data {
+			d := v.Distance(last)
+			if d < dist[idx] {
+				dist[idx] = d
+			}
+			total += dist[idx]
+		}
+
+		if total == 0 {
+			break
+		}
+
+		target := rnd.Float64() * total
+		next := len(data) - 1
+		for idx, d := range dist {
+			target -= d
+			if target < 0 && d > 0 {
+				next = idx
+				break
+			}
+		}
+		labels = append(labels, data[next])
+	}
+	return labels
+}
+
+//RGBの距離、平均の Value
+type rgbValue struct {
+	*Pixel
+}
+
+func newRGBValue(p *Pixel) Value {
+	return rgbValue{p}
+}
+
+func rgbPixel(v Value) (*Pixel, error) {
+	rv, ok := v.(rgbValue)
+	if !ok {
+		return nil, fmt.Errorf("value is not rgb[%T]", v)
+	}
+	return rv.Pixel, nil
+}
+
+func (v rgbValue) Distance(src Value) float64 {
+	return v.DistanceRGB(src.(rgbValue).Pixel)
+}
+
+func (v rgbValue) Average(values []Value) (Value, error) {
+	if len(values) == 0 {
+		return v, nil
+	}
+	p := make(Pixels, len(values))
+	for idx, elm := range values {
+		p[idx] = elm.(rgbValue).Pixel
+	}
+	ave, err := p.Average()
+	if err != nil {
+		return nil, err
+	}
+	return rgbValue{ave}, nil
+}
+
+//kmeansで色を特定
+func kmeans(p *pixelBuffer, k int, itr int, space ColorSpace, rnd *rand.Rand) ([]*Pixel, error) {
+
+	//色の種類が少ない場合はそのまま利用
+	colors := distinctColors(p, k+1)
+	if len(colors) <= k {
+		labels := make([]*Pixel, len(colors))
+		for i, v := range colors {
+			labels[i] = NewPixelRGB(unpackRGB(v))
+		}
+		return labels, nil
+	}
+
+	//色空間の値に変換
+	data := make([][3]float64, p.len())
+	for idx := range p.pix {
+		data[idx] = space.vector(p.rgb(idx))
+	}
+
+	centers := kmeansPlusPlus(data, k, rnd)
+
+	index := make([]int, len(data))
+	for idx, v := range data {
+		index[idx] = closestVector(v, centers)
+	}
+
+	sum := make([][3]float64, k)
+	count := make([]int, k)
+	for idx := 0; idx < itr; idx++ {
+
+		for i := range centers {
+			sum[i] = [3]float64{}
+			count[i] = 0
+		}
+
+		for i, v := range data {
+			label := index[i]
+			sum[label][0] += v[0]
+			sum[label][1] += v[1]
+			sum[label][2] += v[2]
+			count[label]++
+		}
+
+		//空になったラベルは遠い位置から再選定
+		reseed(data, index, centers, count, sum)
+
+		for i := range centers {
+			if count[i] == 0 {
+				continue
+			}
+			ave := 1.0 / float64(count[i])
+			centers[i] = [3]float64{sum[i][0] * ave, sum[i][1] * ave, sum[i][2] * ave}
+		}
+
+		changes := 0
+		for i, v := range data {
+			if newIdx := closestVector(v, centers); newIdx != index[i] {
+				changes++
+				index[i] = newIdx
+			}
+		}
+
+		if changes == 0 {
+			break
+		}
+	}
+
+	labels := make([]*Pixel, k)
+	for i, c := range centers {
+		labels[i] = space.pixel(c)
+	}
+	return labels, nil
+}
+
+//色の種類を取得
+//
+//max まで見つかった時点で終了し、詰めた値の順で返します
+func distinctColors(p *pixelBuffer, max int) []uint32 {
+
+	exists := make(map[uint32]bool)
+	for _, v := range p.pix {
+		exists[v] = true
+		if len(exists) >= max {
+			break
+		}
+	}
+
+	rtn := make([]uint32, 0, len(exists))
+	for v := range exists {
+		rtn = append(rtn, v)
+	}
+	sort.Slice(rtn, func(i, j int) bool {
+		return rtn[i] < rtn[j]
+	})
+	return rtn
+}
+
+//k-means++ による初期値の選定
+//
+//既存のラベルからの距離の2乗に比例した確率で次のラベルを選びます
+func kmeansPlusPlus(data [][3]float64, k int, rnd *rand.Rand) [][3]float64 {
+
+	centers := make([][3]float64, 0, k)
+	centers = append(centers, data[rnd.Intn(len(data))])
+
+	dist := make([]float64, len(data))
+	for idx := range dist {
+		dist[idx] = math.MaxFloat64
+	}
+
+	for len(centers) < k {
+
+		last := centers[len(centers)-1]
+		total := 0.0
+		for idx, v := range data {
+			d := distanceVector(v, last)
+			if d < dist[idx] {
+				dist[idx] = d
+			}
+			total += dist[idx]
+		}
+
+		//すべて同じ色(distinctColorsで除外済)
+		if total == 0 {
+			break
+		}
+
+		target := rnd.Float64() * total
+		next := len(data) - 1
+		for idx, d := range dist {
+			target -= d
+			if target < 0 && d > 0 {
+				next = idx
+				break
+			}
+		}
+		centers = append(centers, data[next])
+	}
+	return centers
+}
+
+//空のラベルを、自身のラベルから最も遠い値で置き換えます
+//
+//置き換えた値の所属、件数、合計も更新します
+func reseed(data [][3]float64, index []int, centers [][3]float64, count []int, sum [][3]float64) {
+
+	used := make(map[int]bool)
+	for i := range centers {
+
+		if count[i] != 0 {
+			continue
+		}
+
+		far := -1
+		max := -1.0
+		for idx, v := range data {
+			if used[idx] || count[index[idx]] <= 1 {
+				continue
+			}
+			d := distanceVector(v, centers[index[idx]])
+			if d > max {
+				max = d
+				far = idx
+			}
+		}
+
+		//移動できる値がない
+		if far == -1 {
+			continue
+		}
+
+		v := data[far]
+		old := index[far]
+		used[far] = true
+		count[old]--
+		for j := range v {
+			sum[old][j] -= v[j]
+		}
+
+		index[far] = i
+		count[i] = 1
+		sum[i] = v
+		centers[i] = v
+	}
+}
+
+//近い位置を取得
+func closestVector(v [3]float64, centers [][3]float64) int {
+	idx := -1
+	d := math.MaxFloat64
+	for i, c := range centers {
+		val := distanceVector(v, c)
+		if val < d {
+			d = val
+			idx = i
+		}
+	}
+	return idx
+}
+
+//距離(2乗)
+func distanceVector(v, c [3]float64) float64 {
+	d0 := v[0] - c[0]
+	d1 := v[1] - c[1]
+	d2 := v[2] - c[2]
+	return d0*d0 + d1*d1 + d2*d2
+}
+
+//前景色への対応付け
+type matcher struct {
+	space  ColorSpace
+	labels []*Pixel
+	vecs   [][3]float64
+
+	//RGBを詰めた値ごとの結果のキャッシュ
+	//計算の方が速い場合はnilになります
+	cache []uint64
+}
+
+//キャッシュの大きさ(ビット数)
+const matcherCacheBits = 16
+
+//RGBでキャッシュを利用する最小のラベル数
+const matcherCacheLabels = 16
+
+//キャッシュの値の有効ビット
+//
+//値は 有効ビット|RGB(24bit)<<16|ラベル+1(16bit) になります
+const matcherCacheValid = 1 << 63
+
+//対応付けの作成
+func newMatcher(labels []*Pixel, space ColorSpace) *matcher {
+	m := matcher{}
+	m.space = space
+	m.labels = labels
+	m.vecs = make([][3]float64, len(labels))
+	for i, label := range labels {
+		m.vecs[i] = space.vector(label.R, label.G, label.B)
+	}
+	if space != RGB || len(labels) >= matcherCacheLabels {
+		m.cache = make([]uint64, 1<<matcherCacheBits)
+	}
+	return &m
+}
+
+//キャッシュを利用して近いラベルを取得
+//
+//v はRGBを詰めた値です
+//同じ位置に別の色が入った場合は上書きするダイレクトマップ方式で、複数の goroutine から呼び出せます
+func (m *matcher) lookup(v uint32) int {
+
+	if m.cache == nil {
+		return m.closest(unpackRGB(v))
+	}
+
+	pos := (v * 2654435761) >> (32 - matcherCacheBits)
+	key := matcherCacheValid | uint64(v)<<16
+
+	e := atomic.LoadUint64(&m.cache[pos])
+	if e&^0xFFFF == key {
+		return int(e&0xFFFF) - 1
+	}
+
+	idx := m.closest(unpackRGB(v))
+	atomic.StoreUint64(&m.cache[pos], key|uint64(idx+1))
+	return idx
+}
+
+//近いラベルを取得
+func (m *matcher) closest(r, g, b uint8) int {
+	if m.space == RGB {
+		return closestRGB(r, g, b, m.labels)
+	}
+	return closestVector(m.space.vector(r, g, b), m.vecs)
+}
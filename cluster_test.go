@@ -0,0 +1,411 @@
+package noteshrink
+
+import (
+	"fmt"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestKMeans(t *testing.T) {
+
+	//黒と青のインク
+	samples := Pixels{
+		NewPixelRGB(10, 10, 10),
+		NewPixelRGB(20, 20, 20),
+		NewPixelRGB(30, 30, 30),
+		NewPixelRGB(10, 20, 200),
+		NewPixelRGB(30, 40, 220),
+	}
+
+	km := &KMeans{Iterate: 40}
+	for seed := int64(0); seed < 10; seed++ {
+
+		labels, err := km.Cluster(samples, 2, rand.New(rand.NewSource(seed)))
+		if err != nil {
+			t.Fatalf("KMeans.Cluster() Error[%v]", err)
+		}
+		if len(labels) != 2 {
+			t.Fatalf("KMeans.Cluster() length error[%d]", len(labels))
+		}
+
+		if !hasColor(labels, color.RGBA{R: 20, G: 20, B: 20}) {
+			t.Errorf("KMeans.Cluster() black not found[%d][%v]", seed, labels)
+		}
+		if !hasColor(labels, color.RGBA{R: 20, G: 30, B: 210}) {
+			t.Errorf("KMeans.Cluster() blue not found[%d][%v]", seed, labels)
+		}
+	}
+
+	_, err := km.Cluster(samples, 0, rand.New(rand.NewSource(0)))
+	if err == nil {
+		t.Errorf("KMeans.Cluster() k zero not error")
+	}
+	_, err = km.Cluster(samples, 2, nil)
+	if err == nil {
+		t.Errorf("KMeans.Cluster() rand nil not error")
+	}
+}
+
+//Test用の選定方法
+type fixedClusterer struct {
+	labels Pixels
+	k      int
+	err    error
+}
+
+func (f *fixedClusterer) Cluster(samples Pixels, k int, rnd *rand.Rand) (Pixels, error) {
+	f.k = k
+	return f.labels, f.err
+}
+
+func TestClusterer(t *testing.T) {
+
+	img := createNote(60, 60, color.RGBA{R: 20, G: 40, B: 200, A: 255})
+
+	fixed := &fixedClusterer{
+		labels: Pixels{NewPixelRGB(0, 0, 0), NewPixelRGB(0, 0, 255)},
+	}
+
+	op := DefaultOption()
+	op.SamplingRate = 0.1
+	op.Clusterer = fixed
+
+	r, err := ShrinkResult(img, op)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+	if fixed.k != op.ForegroundNum-1 {
+		t.Errorf("Clusterer k error[%d]", fixed.k)
+	}
+	if len(r.Foreground) != 2 || r.Foreground[1].B != 255 {
+		t.Errorf("Foreground error[%v]", r.Foreground)
+	}
+	if r.Image.ColorIndexAt(30, 30) != 2 {
+		t.Errorf("Foreground index error[%d]", r.Image.ColorIndexAt(30, 30))
+	}
+
+	//エラー
+	fixed.err = fmt.Errorf("cluster error")
+	_, err = ShrinkResult(img, op)
+	if err == nil {
+		t.Errorf("ShrinkResult() cluster error not error")
+	}
+
+	//色数超過
+	fixed.err = nil
+	fixed.labels = make(Pixels, op.ForegroundNum)
+	for i := range fixed.labels {
+		fixed.labels[i] = NewPixelRGB(uint8(i), 0, 0)
+	}
+	_, err = ShrinkResult(img, op)
+	if err == nil {
+		t.Errorf("ShrinkResult() cluster length over not error")
+	}
+}
+
+func TestValueKMeans(t *testing.T) {
+
+	samples := Pixels{
+		NewPixelRGB(10, 10, 10),
+		NewPixelRGB(20, 20, 20),
+		NewPixelRGB(30, 30, 30),
+		NewPixelRGB(10, 20, 200),
+		NewPixelRGB(30, 40, 220),
+	}
+
+	km := &ValueKMeans{Iterate: 40}
+	for seed := int64(0); seed < 10; seed++ {
+		labels, err := km.Cluster(samples, 2, rand.New(rand.NewSource(seed)))
+		if err != nil {
+			t.Fatalf("ValueKMeans.Cluster() Error[%v]", err)
+		}
+		if !hasColor(labels, color.RGBA{R: 20, G: 20, B: 20}) || !hasColor(labels, color.RGBA{R: 20, G: 30, B: 210}) {
+			t.Errorf("ValueKMeans.Cluster() [%d][%v]", seed, labels)
+		}
+	}
+
+	//Rのみで選定
+	km.NewValue = func(p *Pixel) Value { return redValue(p.R) }
+	km.Pixel = func(v Value) (*Pixel, error) { return NewPixelRGB(uint8(v.(redValue)), 0, 0), nil }
+	samples = Pixels{
+		NewPixelRGB(0, 0, 0),
+		NewPixelRGB(4, 200, 0),
+		NewPixelRGB(250, 0, 200),
+		NewPixelRGB(254, 200, 200),
+	}
+	labels, err := km.Cluster(samples, 2, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatalf("ValueKMeans.Cluster() Error[%v]", err)
+	}
+	if !hasColor(labels, color.RGBA{R: 2}) || !hasColor(labels, color.RGBA{R: 252}) {
+		t.Errorf("ValueKMeans.Cluster() red [%v]", labels)
+	}
+
+	km.Pixel = nil
+	_, err = km.Cluster(samples, 2, rand.New(rand.NewSource(1)))
+	if err == nil {
+		t.Errorf("ValueKMeans.Cluster() Pixel nil not error")
+	}
+
+	//Option から利用
+	op := DefaultOption()
+	op.SamplingRate = 0.1
+	op.Clusterer = &ValueKMeans{Iterate: 40}
+	r, err := ShrinkResult(createNote(60, 60, color.RGBA{R: 20, G: 40, B: 200, A: 255}), op)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+	if !hasColor(r.Foreground, color.RGBA{R: 20, G: 40, B: 200}) {
+		t.Errorf("ShrinkResult() ValueKMeans foreground [%v]", r.Foreground)
+	}
+}
+
+//Test用の Value
+type redValue float64
+
+func (v redValue) Distance(src Value) float64 {
+	d := float64(v - src.(redValue))
+	return d * d
+}
+
+func (v redValue) Average(values []Value) (Value, error) {
+	if len(values) == 0 {
+		return v, nil
+	}
+	sum := 0.0
+	for _, elm := range values {
+		sum += float64(elm.(redValue))
+	}
+	return redValue(sum / float64(len(values))), nil
+}
+
+func TestKMeansFewColors(t *testing.T) {
+
+	km := &KMeans{Iterate: 40}
+	rnd := rand.New(rand.NewSource(0))
+
+	tests := []struct {
+		name    string
+		samples Pixels
+		num     int
+	}{
+		{"empty", Pixels{}, 0},
+		{"single", Pixels{NewPixelRGB(10, 10, 10), NewPixelRGB(10, 10, 10)}, 1},
+		{"two", Pixels{NewPixelRGB(10, 10, 10), NewPixelRGB(0, 0, 200), NewPixelRGB(10, 10, 10)}, 2},
+	}
+
+	for _, test := range tests {
+		labels, err := km.Cluster(test.samples, 5, rnd)
+		if err != nil {
+			t.Errorf("KMeans.Cluster() %s Error[%v]", test.name, err)
+			continue
+		}
+		if len(labels) != test.num {
+			t.Errorf("KMeans.Cluster() %s length error[%d]", test.name, len(labels))
+		}
+	}
+}
+
+func TestKMeansPlusPlus(t *testing.T) {
+
+	data := [][3]float64{
+		{0, 0, 0}, {0, 0, 0}, {0, 0, 0},
+		{255, 255, 255}, {255, 0, 0}, {0, 0, 255},
+	}
+
+	//すべて異なる色が選ばれる
+	for seed := int64(0); seed < 20; seed++ {
+		centers := kmeansPlusPlus(data, 4, rand.New(rand.NewSource(seed)))
+		if len(centers) != 4 {
+			t.Fatalf("kmeansPlusPlus() length error[%d]", len(centers))
+		}
+		exists := make(map[[3]float64]bool)
+		for _, c := range centers {
+			exists[c] = true
+		}
+		if len(exists) != 4 {
+			t.Errorf("kmeansPlusPlus() same center[%d][%v]", seed, centers)
+		}
+	}
+}
+
+func TestReseed(t *testing.T) {
+
+	data := [][3]float64{
+		{0, 0, 0}, {10, 10, 10}, {200, 0, 0}, {250, 250, 250},
+	}
+
+	centers := [][3]float64{{10, 10, 10}, {0, 255, 0}}
+	index := []int{0, 0, 0, 0}
+	count := []int{4, 0}
+	sum := [][3]float64{{460, 260, 260}, {0, 0, 0}}
+
+	reseed(data, index, centers, count, sum)
+
+	if count[0] != 3 || count[1] != 1 {
+		t.Fatalf("reseed() count error[%v]", count)
+	}
+	if index[3] != 1 {
+		t.Errorf("reseed() index error[%v]", index)
+	}
+	if centers[1] != data[3] {
+		t.Errorf("reseed() center error[%v]", centers[1])
+	}
+	if sum[0] != [3]float64{210, 10, 10} || sum[1] != data[3] {
+		t.Errorf("reseed() sum error[%v]", sum)
+	}
+}
+
+func TestKMeansColorSpace(t *testing.T) {
+
+	//黒、紺、薄い鉛筆
+	samples := Pixels{}
+	for i := 0; i < 10; i++ {
+		samples = append(samples,
+			NewPixelRGB(uint8(10+i), uint8(10+i), uint8(10+i)),
+			NewPixelRGB(uint8(10+i), uint8(10+i), uint8(80+i)),
+			NewPixelRGB(uint8(170+i), uint8(170+i), uint8(170+i)),
+		)
+	}
+
+	for _, space := range []ColorSpace{RGB, CIELAB, OKLab} {
+		km := &KMeans{Iterate: 40, Space: space}
+		labels, err := km.Cluster(samples, 3, rand.New(rand.NewSource(1)))
+		if err != nil {
+			t.Fatalf("KMeans.Cluster() %v Error[%v]", space, err)
+		}
+
+		m := newMatcher(labels, space)
+		black := m.closest(10, 10, 10)
+		navy := m.closest(10, 10, 80)
+		pencil := m.closest(170, 170, 170)
+		if black == navy || black == pencil || navy == pencil {
+			t.Errorf("KMeans.Cluster() %v not separated[%v]", space, labels)
+		}
+	}
+}
+
+func TestMatcher(t *testing.T) {
+
+	labels := []*Pixel{
+		NewPixelRGB(0, 0, 0),
+		NewPixelRGB(0, 0, 255),
+		NewPixelRGB(128, 128, 128),
+	}
+
+	tests := []struct {
+		r, g, b  uint8
+		expected int
+	}{
+		{10, 10, 10, 0},
+		{20, 20, 230, 1},
+		{120, 130, 125, 2},
+	}
+
+	for _, space := range []ColorSpace{RGB, CIELAB, OKLab} {
+		m := newMatcher(labels, space)
+		for _, test := range tests {
+			idx := m.closest(test.r, test.g, test.b)
+			if idx != test.expected {
+				t.Errorf("matcher.closest() %v [%d,%d,%d] [%d]!=[%d]",
+					space, test.r, test.g, test.b, idx, test.expected)
+			}
+		}
+	}
+
+	//ラベルが無い場合は背景
+	m := newMatcher(nil, CIELAB)
+	if m.closest(0, 0, 0) != -1 {
+		t.Errorf("matcher.closest() empty labels error")
+	}
+	if m.lookup(0) != -1 || m.lookup(0) != -1 {
+		t.Errorf("matcher.lookup() empty labels error")
+	}
+}
+
+func TestMatcherLookup(t *testing.T) {
+
+	rnd := rand.New(rand.NewSource(1))
+	labels := make(Pixels, 255)
+	for i := range labels {
+		labels[i] = NewPixelRGB(uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)))
+	}
+
+	//キャッシュの大きさより多い色を2回ずつ
+	values := make([]uint32, 1<<(matcherCacheBits+1))
+	for i := range values {
+		values[i] = uint32(rnd.Intn(1 << 24))
+	}
+	values = append(values, 0, 0xFFFFFF, 0, 0xFFFFFF)
+
+	for _, space := range []ColorSpace{RGB, OKLab} {
+		m := newMatcher(labels, space)
+		for n := 0; n < 2; n++ {
+			for _, v := range values {
+				expected := m.closest(unpackRGB(v))
+				if idx := m.lookup(v); idx != expected {
+					t.Fatalf("matcher.lookup() %v [%06x] [%d]!=[%d]", space, v, idx, expected)
+				}
+			}
+		}
+	}
+}
+
+//キャッシュを利用しない場合との比較用
+func BenchmarkMatcherClosest(b *testing.B) {
+	for _, space := range []ColorSpace{RGB, CIELAB, OKLab} {
+		b.Run(space.String(), func(b *testing.B) {
+			m, data := benchmarkMatcher(b, space)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, v := range data.pix {
+					m.closest(unpackRGB(v))
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMatcherLookup(b *testing.B) {
+	for _, space := range []ColorSpace{RGB, CIELAB, OKLab} {
+		b.Run(space.String(), func(b *testing.B) {
+			m, data := benchmarkMatcher(b, space)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				//パレットごとに作成する為、キャッシュは毎回空から始める
+				m = newMatcher(m.labels, space)
+				for _, v := range data.pix {
+					m.lookup(v)
+				}
+			}
+		})
+	}
+}
+
+//サンプル画像の前景色の画素と前景色
+func benchmarkMatcher(b *testing.B, space ColorSpace) (*matcher, *pixelBuffer) {
+
+	img, err := loadImage("sample/notesA1.jpg")
+	if err != nil {
+		b.Fatalf("loadImage() Error[%v]", err)
+	}
+	op := DefaultOption()
+	op.ColorSpace = space
+	r, err := ShrinkResult(img, op)
+	if err != nil {
+		b.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+	data, err := convertPixels(img)
+	if err != nil {
+		b.Fatalf("convertPixels() Error[%v]", err)
+	}
+
+	index := make([]int, 0)
+	for idx, m := range r.Mask {
+		if m {
+			index = append(index, idx)
+		}
+	}
+	return newMatcher(r.Foreground, op.ColorSpace), data.subset(index)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//入力ファイルの基準ディレクトリ
+//
+//-outdir 指定時はこのディレクトリからの相対パスで出力します
+//ディレクトリの引数はそのディレクトリ、パターンは固定部分のディレクトリ、それ以外は作業ディレクトリになります
+var inputRoot = make(map[string]string)
+
+//引数のファイルを展開
+//
+//ディレクトリは -include に一致するファイルを再帰的に、存在しない引数はパターンとして展開します
+//既にサフィックスが付いているファイルは、直接指定した場合も除きます(シェルで展開したパターン)
+//ファイル、パターンに一致しない引数は変換時に失敗させる為、そのまま返します
+func expandArgs(args []string) ([]string, error) {
+
+	rtn := make([]string, 0, len(args))
+	for _, arg := range args {
+
+		if arg == "-" {
+			rtn = append(rtn, arg)
+			continue
+		}
+
+		info, err := os.Stat(arg)
+		if err == nil {
+			if !info.IsDir() {
+				if hasSuffix(arg) {
+					log.Printf("Skip      : [%s][suffix %s]\n", arg, *suffixVal)
+					continue
+				}
+				rtn = append(rtn, arg)
+				inputRoot[arg] = "."
+				continue
+			}
+			files, err := walkDir(arg)
+			if err != nil {
+				return nil, err
+			}
+			rtn = append(rtn, files...)
+			continue
+		}
+
+		//パターンとして展開
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("pattern error[%s][%v]", arg, err)
+		}
+		if len(matches) == 0 {
+			rtn = append(rtn, arg)
+			continue
+		}
+		sort.Strings(matches)
+		root := globRoot(arg)
+		for _, f := range matches {
+			if info, err := os.Stat(f); err == nil && !info.IsDir() && !hasSuffix(f) {
+				rtn = append(rtn, f)
+				inputRoot[f] = root
+			}
+		}
+	}
+	return rtn, nil
+}
+
+//パターンの固定部分のディレクトリ
+func globRoot(pattern string) string {
+	dir := filepath.Dir(pattern)
+	for dir != "." && dir != filepath.Dir(dir) && strings.ContainsAny(dir, "*?[\\") {
+		dir = filepath.Dir(dir)
+	}
+	if strings.ContainsAny(dir, "*?[\\") {
+		return "."
+	}
+	return dir
+}
+
+//ディレクトリ以下の変換対象のファイル
+func walkDir(root string) ([]string, error) {
+
+	rtn := make([]string, 0)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || hasSuffix(path) {
+			return nil
+		}
+
+		ok, err := include(path)
+		if err != nil {
+			return err
+		}
+		if ok {
+			rtn = append(rtn, path)
+			inputRoot[path] = root
+		}
+		return nil
+	})
+	return rtn, err
+}
+
+//-include のパターン(カンマ区切り)に一致するか
+//
+//ファイル名で大文字、小文字を区別せずに比較します
+func include(path string) (bool, error) {
+	name := strings.ToLower(filepath.Base(path))
+	for _, pattern := range strings.Split(*includeVal, ",") {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		ok, err := filepath.Match(pattern, name)
+		if err != nil {
+			return false, fmt.Errorf("include pattern error[%s][%v]", pattern, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//既に変換したファイル名(拡張子を除いてサフィックスで終わる)か
+func hasSuffix(path string) bool {
+	if *suffixVal == "" {
+		return false
+	}
+	name := filepath.Base(path)
+	return strings.HasSuffix(strings.TrimSuffix(name, filepath.Ext(name)), *suffixVal)
+}
+
+//出力ファイル名
+//
+//-oを指定した場合はその名前、それ以外は入力ファイル名にサフィックスを付けます
+//-outdir を指定した場合はそのディレクトリに、ディレクトリの指定で展開したファイルは構成を再現して出力します
+func outputName(f string) string {
+
+	if *outputVal != "" {
+		return *outputVal
+	}
+
+	format, _ := outputFormat()
+	name := strings.TrimSuffix(f, filepath.Ext(f)) + *suffixVal + "." + format
+	if *outdirVal == "" {
+		return name
+	}
+
+	//基準ディレクトリの外は名前のみ
+	rel, err := relPath(inputRoot[f], name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.Join(*outdirVal, filepath.Base(name))
+	}
+	return filepath.Join(*outdirVal, rel)
+}
+
+//基準ディレクトリからの相対パス
+//
+//root を指定しない場合は作業ディレクトリからになります
+func relPath(root, name string) (string, error) {
+
+	if root == "" {
+		root = "."
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	absName, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(absRoot, absName)
+}
+
+//出力ファイル名の重複を確認
+//
+//異なる入力が同じファイルに出力される場合はエラーを返します
+func checkOutputs(files []string) error {
+
+	used := make(map[string]string)
+	for _, f := range files {
+		if f == "-" && *outputVal == "" {
+			continue
+		}
+		output := outputName(f)
+		if output == "-" {
+			continue
+		}
+		key, err := filepath.Abs(output)
+		if err != nil {
+			return err
+		}
+		if prev, ok := used[key]; ok {
+			return fmt.Errorf("output file duplicated[%s][%s]->[%s]", prev, f, output)
+		}
+		used[key] = f
+	}
+	return nil
+}
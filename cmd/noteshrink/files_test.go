@@ -0,0 +1,190 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandArgs(t *testing.T) {
+
+	dir := t.TempDir()
+	createFiles(t, dir,
+		"v1.2/a.jpg",
+		"v1.2/sub/B.PNG",
+		"v1.2/sub/b_min.png",
+		"v1.2/readme.txt",
+		"c.jpg",
+		"c_min.png",
+	)
+	inputRoot = make(map[string]string)
+
+	missing := filepath.Join(dir, "missing.jpg")
+	files, err := expandArgs([]string{
+		filepath.Join(dir, "v1.2"),
+		filepath.Join(dir, "*.*"),
+		filepath.Join(dir, "c_min.png"),
+		missing,
+		"-",
+	})
+	if err != nil {
+		t.Fatalf("expandArgs() Error[%v]", err)
+	}
+
+	expected := []string{
+		filepath.Join(dir, "v1.2/a.jpg"),
+		filepath.Join(dir, "v1.2/sub/B.PNG"),
+		filepath.Join(dir, "c.jpg"),
+		missing,
+		"-",
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expandArgs() files\n[%v]\n[%v]", files, expected)
+	}
+	if inputRoot[expected[1]] != filepath.Join(dir, "v1.2") {
+		t.Errorf("expandArgs() directory root[%s]", inputRoot[expected[1]])
+	}
+	if inputRoot[expected[2]] != dir {
+		t.Errorf("expandArgs() pattern root[%s]", inputRoot[expected[2]])
+	}
+
+	setFlag(t, includeVal, "*.png")
+	files, err = expandArgs([]string{filepath.Join(dir, "v1.2")})
+	if err != nil {
+		t.Fatalf("expandArgs() Error[%v]", err)
+	}
+	if len(files) != 1 || files[0] != expected[1] {
+		t.Errorf("expandArgs() include[%v]", files)
+	}
+
+	_, err = expandArgs([]string{"["})
+	if err == nil {
+		t.Errorf("expandArgs() pattern error not error")
+	}
+}
+
+func TestGlobRoot(t *testing.T) {
+	tests := map[string]string{
+		"*.jpg":            ".",
+		"notes/*.jpg":      "notes",
+		"notes/a/*/p*.jpg": filepath.Join("notes", "a"),
+		"*/a/*.jpg":        ".",
+		"/tmp/x/[ab].jpg":  "/tmp/x",
+	}
+	for pattern, expected := range tests {
+		if root := globRoot(pattern); root != expected {
+			t.Errorf("globRoot() [%s] [%s]!=[%s]", pattern, root, expected)
+		}
+	}
+}
+
+func TestHasSuffix(t *testing.T) {
+
+	tests := map[string]bool{
+		"a.jpg":          false,
+		"a_min.png":      true,
+		"dir_min/a.jpg":  false,
+		"v1.2/a_min.gif": true,
+		"a_min":          true,
+		"a_minimum.png":  false,
+	}
+	for f, expected := range tests {
+		if hasSuffix(f) != expected {
+			t.Errorf("hasSuffix() [%s] %v", f, !expected)
+		}
+	}
+
+	setFlag(t, suffixVal, "")
+	if hasSuffix("a_min.png") {
+		t.Errorf("hasSuffix() empty suffix")
+	}
+}
+
+func TestOutputName(t *testing.T) {
+
+	inputRoot = map[string]string{
+		filepath.Join("in", "v1.2", "sub", "a.jpg"): "in",
+		filepath.Join("a", "page.jpg"):              ".",
+		filepath.Join("b", "page.jpg"):              ".",
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		outdir   string
+		expected string
+	}{
+		{"dot directory", "v1.2/page", "", "v1.2/page_min.png"},
+		{"extension", "v1.2/page.jpeg", "", "v1.2/page_min.png"},
+		{"directory", "in/v1.2/sub/a.jpg", "out", "out/v1.2/sub/a_min.png"},
+		{"file", "a/page.jpg", "out", "out/a/page_min.png"},
+		{"outside", "../page.jpg", "out", "out/page_min.png"},
+	}
+	for _, test := range tests {
+		setFlag(t, outdirVal, test.outdir)
+		name := outputName(filepath.FromSlash(test.input))
+		if name != filepath.FromSlash(test.expected) {
+			t.Errorf("outputName() %s [%s]!=[%s]", test.name, name, test.expected)
+		}
+	}
+
+	setFlag(t, outdirVal, "out")
+	setFlag(t, formatVal, "gif")
+	if name := outputName("a/page.jpg"); name != filepath.FromSlash("out/a/page_min.gif") {
+		t.Errorf("outputName() format [%s]", name)
+	}
+
+	setFlag(t, outputVal, "x.png")
+	if name := outputName("a/page.jpg"); name != "x.png" {
+		t.Errorf("outputName() -o [%s]", name)
+	}
+}
+
+func TestCheckOutputs(t *testing.T) {
+
+	inputRoot = make(map[string]string)
+	setFlag(t, outdirVal, "out")
+
+	err := checkOutputs([]string{"a/page.jpg", "b/page.jpg"})
+	if err != nil {
+		t.Errorf("checkOutputs() Error[%v]", err)
+	}
+
+	//基準ディレクトリの外は名前のみの為、重複
+	err = checkOutputs([]string{"../a/page.jpg", "../b/page.jpg"})
+	if err == nil {
+		t.Errorf("checkOutputs() duplicate not error")
+	}
+
+	setFlag(t, outdirVal, "")
+	err = checkOutputs([]string{"a/page.jpg", "a/page.png"})
+	if err == nil {
+		t.Errorf("checkOutputs() extension duplicate not error")
+	}
+}
+
+//Test用のツール
+//flagの値を変更し、終了時に戻します
+func setFlag(t *testing.T, p *string, v string) {
+	t.Helper()
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+//空のファイルを作成
+func createFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		f := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(f), 0755)
+		if err != nil {
+			t.Fatalf("MkdirAll() Error[%v]", err)
+		}
+		err = os.WriteFile(f, nil, 0644)
+		if err != nil {
+			t.Fatalf("WriteFile() Error[%v]", err)
+		}
+	}
+}
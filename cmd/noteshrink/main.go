@@ -4,11 +4,13 @@ import (
 	"flag"
 	"fmt"
 	"image"
-	_ "image/jpeg"
-	_ "image/png"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
 	"runtime/pprof"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -21,13 +23,49 @@ var (
 
 	brightnessOpt = flag.Float64("b", 0.35, "前景色選定時のVの距離")
 	saturationOpt = flag.Float64("s", 0.25, "前景色選定時のSの距離")
+	hueOpt        = flag.Float64("hue", 0, "前景色選定時のHの距離(0-0.5)。0の場合は利用しない")
+	autoOpt       = flag.Bool("auto", false, "前景色選定時のV,Sの距離を画像から決定する(-b,-sは利用しない)")
 
 	foregroundNumOpt = flag.Int("f", 6, "前景色に選ばれる数を指定")
 	iterateOpt       = flag.Int("i", 40, "kmeans のループ数")
 
+	whiteOpt    = flag.Bool("w", false, "背景色を白にする")
+	saturateOpt = flag.Bool("S", false, "前景色の彩度、明度を最大範囲まで広げる")
+	seedOpt     = flag.Int64("seed", 0, "サンプリングで利用する乱数のシード")
+	spaceOpt    = flag.String("space", "rgb", "前景色の選定、適用時の色空間(rgb,lab,oklab)")
+
+	bgOpt         = flag.String("bg", "frequent", "背景色の推定方法(frequent,border,bright)。#RRGGBBの場合はその色を背景色にする")
+	percentileOpt = flag.Float64("bgp", 0.1, "-bg bright で平均する明るい画素の割合")
+	flattenOpt    = flag.Bool("flat", false, "照明ムラを補正してから変換する")
+	flatSizeOpt   = flag.Int("flatsize", 0, "照明ムラを推定するブロックの大きさ(画素)。0の場合は短辺の1/16")
+
+	adaptiveOpt = flag.Bool("adaptive", false, "周囲の明るさ、彩度で前景色を判定する(-auto,-hueは利用できない)")
+	windowOpt   = flag.Int("window", 0, "-adaptive で統計を取る範囲の大きさ(画素)。0の場合は短辺の1/16")
+	sauvolaOpt  = flag.Float64("k", 0.2, "-adaptive の明るさの閾値の係数")
+
+	openOpt    = flag.Int("open", 0, "前景色の判定後のオープニングの半径(画素)")
+	closeOpt   = flag.Int("close", 0, "前景色の判定後のクロージングの半径(画素)")
+	minAreaOpt = flag.Int("minarea", 0, "前景色として残す連結領域の最小の画素数")
+
 	profileVal = flag.String("p", "", "プロファイル名（指定しない場合プロファイルを行わない）")
 	suffixVal  = flag.String("suffix", "_min", "変換ファイル名のサフィックス")
 	gifVal     = flag.Bool("g", false, "GIF化したもの")
+	outputVal  = flag.String("o", "", "出力ファイル名(-の場合は標準出力)。1ファイルの変換時のみ指定できる")
+	formatVal  = flag.String("format", "", "出力形式(png,gif)。指定しない場合は-oの拡張子、-gから決定する")
+	outdirVal  = flag.String("outdir", "", "出力ディレクトリ。ディレクトリを指定した入力は構成を再現して出力する")
+	includeVal = flag.String("include", "*.jpg,*.jpeg,*.png,*.gif", "ディレクトリを指定した場合に変換するファイル名のパターン(カンマ区切り)")
+	globalVal  = flag.Bool("global", false, "全ファイルで共通のパレットを利用する。パレットの作成時と変換時に各ファイルを1つずつ読み込む")
+
+	pdfVal  = flag.String("pdf", "", "指定したPDFファイルに引数の順序で全ページを出力する")
+	dpiVal  = flag.Float64("dpi", 300, "PDF出力時の画像の解像度")
+	pageVal = flag.String("page", "", "PDF出力時のページサイズ(a4,letter)。指定しない場合は画像の大きさ")
+
+	jobsVal    = flag.Int("j", runtime.GOMAXPROCS(0), "同時に変換するファイル数")
+	workersVal = flag.Int("workers", 1, "1ファイルの前景色の判定、適用を行う goroutine の数")
+	tileVal    = flag.Int("tile", 0, "指定した行数ずつ変換してPNGを出力する(-g,-pdf,-globalは利用できない)")
+
+	manifestVal = flag.String("manifest", "", "変換結果を記録するJSONファイル。記録と入力、オプションが同じで出力が新しいファイルは変換しない(-pdf,-globalは利用できない)")
+	forceVal    = flag.Bool("force", false, "-manifest の記録に関わらず全ファイルを変換する")
 )
 
 func Usage() {
@@ -37,6 +75,25 @@ func Usage() {
 
 //https://mzucker.github.io/2016/09/20/noteshrink.html
 func main() {
+	os.Exit(execute())
+}
+
+//#RRGGBB形式の色を解析
+func parseColor(v string) (*noteshrink.Pixel, error) {
+
+	if len(v) != 7 || v[0] != '#' {
+		return nil, fmt.Errorf("color format is #RRGGBB")
+	}
+
+	rgb, err := strconv.ParseUint(v[1:], 16, 32)
+	if err != nil {
+		return nil, err
+	}
+	return noteshrink.NewPixelRGB(uint8(rgb>>16), uint8(rgb>>8), uint8(rgb)), nil
+}
+
+//変換処理を行い、終了コードを返す
+func execute() int {
 
 	//flagを処理
 	flag.Parse()
@@ -51,92 +108,475 @@ func main() {
 		Shift:         *shiftOpt,
 		Brightness:    *brightnessOpt,
 		Saturation:    *saturationOpt,
+		Hue:           *hueOpt,
 		ForegroundNum: *foregroundNumOpt,
 		Iterate:       *iterateOpt,
+
+		WhiteBackground: *whiteOpt,
+		Saturate:        *saturateOpt,
+		Seed:            *seedOpt,
+		AutoThreshold:   *autoOpt,
+		Flatten:         *flattenOpt,
+		FlattenSize:     *flatSizeOpt,
+		WindowSize:      *windowOpt,
+		SauvolaK:        *sauvolaOpt,
+		OpenRadius:      *openOpt,
+		CloseRadius:     *closeOpt,
+		MinArea:         *minAreaOpt,
+		Workers:         *workersVal,
+		TileRows:        *tileVal,
+	}
+	if *adaptiveOpt {
+		opt.MaskMode = noteshrink.AdaptiveMask
+	}
+
+	switch strings.ToLower(*spaceOpt) {
+	case "rgb":
+		opt.ColorSpace = noteshrink.RGB
+	case "lab":
+		opt.ColorSpace = noteshrink.CIELAB
+	case "oklab":
+		opt.ColorSpace = noteshrink.OKLab
+	default:
+		fmt.Fprintf(os.Stderr, "color space not supported[%s]\n", *spaceOpt)
+		return 2
+	}
+
+	opt.BackgroundPercentile = *percentileOpt
+	switch bg := strings.ToLower(*bgOpt); bg {
+	case "frequent":
+		opt.BackgroundMode = noteshrink.MostFrequent
+	case "border":
+		opt.BackgroundMode = noteshrink.BorderSampling
+	case "bright":
+		opt.BackgroundMode = noteshrink.BrightestPercentile
+	default:
+		c, err := parseColor(bg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "background not supported[%s][%v]\n", *bgOpt, err)
+			return 2
+		}
+		opt.BackgroundMode = noteshrink.FixedBackground
+		opt.BackgroundColor = c
 	}
 
 	//ファイル名を処理する
-	files := flag.Args()
-	if files == nil || len(files) == 0 {
+	if flag.NArg() == 0 {
 		Usage()
-		return
+		return 2
+	}
+	files, err := expandArgs(flag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "input files not found\n")
+		return 2
 	}
 
-	//各処理を非同期で行う
-	wg := sync.WaitGroup{}
+	format, err := outputFormat()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	if *outputVal != "" && (len(files) != 1 || *pdfVal != "") {
+		fmt.Fprintf(os.Stderr, "-o supported with single file only\n")
+		return 2
+	}
 	for _, f := range files {
+		if f == "-" && *outputVal == "" && *pdfVal == "" {
+			fmt.Fprintf(os.Stderr, "-o required with stdin input\n")
+			return 2
+		}
+	}
+
+	if *pdfVal == "" {
+		err = checkOutputs(files)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+	}
+
+	if *tileVal > 0 && (format != "png" || *pdfVal != "" || *globalVal) {
+		fmt.Fprintf(os.Stderr, "-tile supported with png only and not supported with -pdf,-global\n")
+		return 2
+	}
+
+	var man *manifest
+	if *manifestVal != "" {
+		if *pdfVal != "" || *globalVal {
+			fmt.Fprintf(os.Stderr, "-manifest not supported with -pdf,-global\n")
+			return 2
+		}
+		man, err = loadManifest(*manifestVal)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+	}
+
+	jobs := *jobsVal
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	//PDF出力時は変換の終わったページから書き込む
+	var pages *pdfPages
+	if *pdfVal != "" {
+		pages, err = newPDFPages(*pdfVal, jobs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+	}
+
+	errs := make([]error, len(files))
+	skipped := make([]bool, len(files))
+
+	//ファイルごとの変換
+	convert := func(idx int) (*noteshrink.Result, error) {
+		if man != nil {
+			var err error
+			skipped[idx], err = runIncremental(files[idx], &opt, man)
+			return nil, err
+		}
+		return run(files[idx], &opt)
+	}
+
+	if *globalVal {
+		//共通のパレットを作成してから変換
+		p, perrs, err := buildGlobal(files, &opt)
+		if err != nil {
+			for idx := range errs {
+				errs[idx] = err
+			}
+		} else {
+			copy(errs, perrs)
+		}
+		convert = func(idx int) (*noteshrink.Result, error) {
+			return runPalette(files[idx], p, &opt)
+		}
+	}
+
+	//指定数で処理を行う
+	ch := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < jobs; w++ {
 		wg.Add(1)
-		go func(file string) {
-			err := run(file, &opt)
-			if err != nil {
-				fmt.Printf("[%v]\n", err)
+		go func() {
+			defer wg.Done()
+			for idx := range ch {
+				var r *noteshrink.Result
+				if errs[idx] == nil {
+					r, errs[idx] = convert(idx)
+				}
+				if pages != nil {
+					pages.put(idx, r)
+				}
 			}
-			wg.Done()
-		}(f)
+		}()
 	}
+
+	for idx := range files {
+		if pages != nil {
+			pages.wait(idx)
+		}
+		ch <- idx
+	}
+	close(ch)
 	wg.Wait()
 
-	return
+	code := 0
+	if pages != nil {
+		err := pages.close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%v]\n", err)
+			code = 1
+		}
+	}
+
+	if man != nil {
+		err := man.save(*manifestVal)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "manifest write error[%s][%v]\n", *manifestVal, err)
+			code = 1
+		}
+	}
+
+	//結果の表示
+	failed := 0
+	skip := 0
+	for idx, err := range errs {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed    : [%s][%v]\n", files[idx], err)
+			failed++
+		} else if skipped[idx] {
+			skip++
+		}
+	}
+	if man != nil {
+		log.Printf("Succeeded : [%d] Skipped : [%d] Failed : [%d]\n", len(files)-failed-skip, skip, failed)
+	} else {
+		log.Printf("Succeeded : [%d] Failed : [%d]\n", len(files)-failed, failed)
+	}
+
+	if failed > 0 {
+		code = 1
+	}
+	return code
 }
 
 //ファイル変換の実行
-func run(f string, opt *noteshrink.Option) error {
+//
+//PDF出力時は結果を返し、それ以外はファイル出力を行います
+func run(f string, opt *noteshrink.Option) (*noteshrink.Result, error) {
 
 	log.Printf("Shrink    : [%s]\n", f)
 
 	//画像の読み込み
 	img, err := loadImage(f)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if *tileVal > 0 {
+		return nil, runTiled(f, img, opt)
 	}
 
 	//圧縮
-	shrink, err := noteshrink.Shrink(img, opt)
+	shrink, err := noteshrink.ShrinkResult(img, opt)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	for _, w := range shrink.Warnings {
+		log.Printf("Warning   : [%s][%s]\n", f, w)
+	}
+	if opt.AutoThreshold {
+		log.Printf("Threshold : [%s][b=%.3f s=%.3f]\n", f, shrink.Brightness, shrink.Saturation)
 	}
+	return outputShrink(f, shrink)
+}
 
-	output := ""
-	ext := ".png"
-	if *gifVal {
-		ext = ".gif"
+//変換結果の出力
+//
+//PDF出力時は結果を返し、それ以外はファイル出力を行います
+func outputShrink(f string, shrink *noteshrink.Result) (*noteshrink.Result, error) {
+	if *pdfVal != "" {
+		//PDFで利用しない判定は破棄
+		shrink.Mask = nil
+		return shrink, nil
 	}
-	idx := strings.LastIndex(f, ".")
-	//出力ファイル名
-	if idx == -1 {
-		output = f + *suffixVal + ext
-	} else {
-		output = f[:idx] + *suffixVal + ext
+	return nil, outputResult(f, shrink)
+}
+
+//マニフェストを利用したファイル変換の実行
+//
+//変換済の場合は変換せずに true を返します
+func runIncremental(f string, opt *noteshrink.Option, man *manifest) (bool, error) {
+
+	//標準入力は記録しない
+	if f == "-" {
+		_, err := run(f, opt)
+		return false, err
 	}
 
-	//出力の切り替え
-	if *gifVal {
-		err = noteshrink.OutputGIF(output, shrink)
-	} else {
-		err = noteshrink.OutputPNG(output, shrink)
+	hash, err := fileHash(f)
+	if err != nil {
+		return false, err
+	}
+	output := outputName(f)
+	opts := optionSet()
+	if !*forceVal && man.upToDate(f, hash, output, opts) {
+		log.Printf("Skip      : [%s]\n", f)
+		return true, nil
 	}
 
-	if err == nil {
-		log.Printf("Generated : [%s]\n", output)
+	_, err = run(f, opt)
+	if err != nil {
+		man.remove(f)
+		return false, err
+	}
+	man.record(f, hash, output, opts)
+	return false, nil
+}
+
+//タイルに分けて変換し、PNGを出力
+func runTiled(f string, img image.Image, opt *noteshrink.Option) error {
+
+	output := outputName(f)
+	var p *noteshrink.Palette
+	err := writeOutput(output, func(w io.Writer) error {
+		var err error
+		p, err = noteshrink.ShrinkTiled(w, img, opt)
+		return err
+	})
+	if err != nil {
+		return err
 	}
 
-	return err
+	for _, w := range p.Warnings {
+		log.Printf("Warning   : [%s][%s]\n", f, w)
+	}
+	log.Printf("Generated : [%s]\n", output)
+	return nil
 }
 
-//画像の読み込み
-func loadImage(f string) (image.Image, error) {
-	file, err := os.Open(f)
+//共通のパレットを作成
+//
+//1ファイルずつ読み込んでサンプルを追加する為、全ファイルを同時に展開しません
+//読み込めなかったファイルはパレットに含めず、ファイルごとのエラーで返します
+func buildGlobal(files []string, opt *noteshrink.Option) (*noteshrink.Palette, []error, error) {
+
+	b, err := noteshrink.NewPaletteBuilder(opt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	errs := make([]error, len(files))
+	for idx, f := range files {
+		log.Printf("Load      : [%s]\n", f)
+		img, err := loadImage(f)
+		if err == nil {
+			err = b.Add(img)
+		}
+		errs[idx] = err
+	}
+
+	p, err := b.Palette()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, w := range p.Warnings {
+		log.Printf("Warning   : [%s]\n", w)
+	}
+	if opt.AutoThreshold {
+		log.Printf("Threshold : [b=%.3f s=%.3f]\n", p.Brightness, p.Saturation)
+	}
+	return p, errs, nil
+}
+
+//共通のパレットでファイル変換を実行
+func runPalette(f string, p *noteshrink.Palette, opt *noteshrink.Option) (*noteshrink.Result, error) {
+
+	log.Printf("Shrink    : [%s]\n", f)
+
+	//画像の読み込み
+	img, err := loadImage(f)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	img, _, err := image.Decode(file)
+	//圧縮
+	shrink, err := noteshrink.ShrinkPalette(img, p, opt)
 	if err != nil {
 		return nil, err
 	}
+	return outputShrink(f, shrink)
+}
+
+//出力形式
+//
+//-format、-oの拡張子、-gの順に決定します
+func outputFormat() (string, error) {
 
-	return img, nil
+	format := strings.ToLower(*formatVal)
+	if format == "" {
+		ext := ""
+		if *outputVal != "-" {
+			ext = strings.ToLower(strings.TrimPrefix(filepath.Ext(*outputVal), "."))
+		}
+		switch {
+		case ext != "":
+			format = ext
+		case *gifVal:
+			format = "gif"
+		default:
+			format = "png"
+		}
+	}
+
+	switch format {
+	case "png", "gif":
+		return format, nil
+	}
+	return "", fmt.Errorf("format not supported[%s]", format)
+}
+
+//出力先に書き込み
+//
+//-の場合は標準出力に書き込みます
+func writeOutput(output string, fn func(w io.Writer) error) error {
+
+	if output == "-" {
+		return fn(os.Stdout)
+	}
+
+	if *outdirVal != "" {
+		err := os.MkdirAll(filepath.Dir(output), 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	err = fn(out)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+//変換結果の出力
+func outputResult(f string, shrink *noteshrink.Result) error {
+
+	output := outputName(f)
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+
+	//出力の切り替え
+	err = writeOutput(output, func(w io.Writer) error {
+		if format == "gif" {
+			return noteshrink.EncodeGIF(w, shrink.Image)
+		}
+		return noteshrink.EncodePNG(w, shrink.Image)
+	})
+
+	if err == nil {
+		log.Printf("Generated : [%s]\n", output)
+	}
+
+	return err
+}
+
+//標準入力の画像
+//
+//-global では2回読み込む為、最初に読み込んだ画像を保持します
+var stdinImage struct {
+	once sync.Once
+	img  image.Image
+	err  error
+}
+
+//画像の読み込み
+//
+//-の場合は標準入力から読み込みます
+func loadImage(f string) (image.Image, error) {
+	if f == "-" {
+		stdinImage.once.Do(func() {
+			stdinImage.img, _, stdinImage.err = noteshrink.Decode(os.Stdin)
+		})
+		return stdinImage.img, stdinImage.err
+	}
+	return noteshrink.DecodeFile(f)
 }
 
 type profile struct {
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+//マニフェストの形式のバージョン
+const manifestVersion = 1
+
+//変換結果に影響しないフラグ
+//
+//これ以外のフラグの値をオプションとしてマニフェストに記録します
+var ignoreFlags = map[string]bool{
+	"p": true, "o": true, "outdir": true, "include": true,
+	"j": true, "workers": true, "tile": true,
+	"pdf": true, "dpi": true, "page": true, "global": true,
+	"manifest": true, "force": true,
+}
+
+//変換済のファイルの一覧
+type manifest struct {
+	Version int              `json:"version"`
+	Entries []*manifestEntry `json:"entries"`
+
+	mutex sync.Mutex
+	index map[string]*manifestEntry
+}
+
+//変換したファイルの情報
+type manifestEntry struct {
+	Input   string            `json:"input"`
+	Hash    string            `json:"hash"`
+	Options map[string]string `json:"options"`
+	Output  string            `json:"output"`
+	Updated time.Time         `json:"updated"`
+}
+
+//マニフェストの読み込み
+//
+//ファイルが存在しない場合は空のマニフェストを返します
+func loadManifest(name string) (*manifest, error) {
+
+	m := manifest{Version: manifestVersion}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		m.index = make(map[string]*manifestEntry)
+		return &m, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return nil, fmt.Errorf("manifest format error[%s][%v]", name, err)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("manifest version not supported[%s][%d]", name, m.Version)
+	}
+
+	m.index = make(map[string]*manifestEntry)
+	for _, e := range m.Entries {
+		m.index[e.Input] = e
+	}
+	return &m, nil
+}
+
+//マニフェストの書き込み
+//
+//途中で失敗しても既存のファイルを壊さない様に、一時ファイルに書き込んでから置き換えます
+func (m *manifest) save(name string) error {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.Entries = make([]*manifestEntry, 0, len(m.index))
+	for _, e := range m.index {
+		m.Entries = append(m.Entries, e)
+	}
+	sort.Slice(m.Entries, func(i, j int) bool {
+		return m.Entries[i].Input < m.Entries[j].Input
+	})
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+//変換が不要か
+//
+//入力のハッシュ、オプション、出力先が記録と同じで、出力が入力より新しい場合に変換済とします
+func (m *manifest) upToDate(f, hash, output string, opts map[string]string) bool {
+
+	m.mutex.Lock()
+	e, ok := m.index[f]
+	m.mutex.Unlock()
+	if !ok {
+		return false
+	}
+	if e.Hash != hash || e.Output != output || !reflect.DeepEqual(e.Options, opts) {
+		return false
+	}
+
+	in, err := os.Stat(f)
+	if err != nil {
+		return false
+	}
+	out, err := os.Stat(output)
+	if err != nil {
+		return false
+	}
+	return !out.ModTime().Before(in.ModTime())
+}
+
+//変換結果を記録
+func (m *manifest) record(f, hash, output string, opts map[string]string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.index[f] = &manifestEntry{
+		Input:   f,
+		Hash:    hash,
+		Options: opts,
+		Output:  output,
+		Updated: time.Now(),
+	}
+}
+
+//変換に失敗したファイルの記録を削除
+func (m *manifest) remove(f string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.index, f)
+}
+
+//変換結果に影響するフラグの値
+func optionSet() map[string]string {
+	rtn := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		if !ignoreFlags[f.Name] {
+			rtn[f.Name] = f.Value.String()
+		}
+	})
+	format, _ := outputFormat()
+	rtn["format"] = format
+	return rtn
+}
+
+//ファイルのハッシュ(SHA-256)
+func fileHash(f string) (string, error) {
+
+	r, err := os.Open(f)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shizuokago/noteshrink"
+)
+
+func TestManifestSave(t *testing.T) {
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "manifest.json")
+
+	//存在しない場合は空
+	m, err := loadManifest(name)
+	if err != nil {
+		t.Fatalf("loadManifest() Error[%v]", err)
+	}
+	if len(m.index) != 0 {
+		t.Errorf("loadManifest() not empty[%d]", len(m.index))
+	}
+
+	opts := map[string]string{"b": "0.35", "format": "png"}
+	m.record("b.jpg", "sha256:bb", "b_min.png", opts)
+	m.record("a.jpg", "sha256:aa", "a_min.png", opts)
+	m.record("c.jpg", "sha256:cc", "c_min.png", opts)
+	m.remove("c.jpg")
+	err = m.save(name)
+	if err != nil {
+		t.Fatalf("manifest.save() Error[%v]", err)
+	}
+
+	load, err := loadManifest(name)
+	if err != nil {
+		t.Fatalf("loadManifest() Error[%v]", err)
+	}
+	if len(load.Entries) != 2 || load.Entries[0].Input != "a.jpg" || load.Entries[1].Input != "b.jpg" {
+		t.Fatalf("loadManifest() entries error[%v]", load.Entries)
+	}
+	e := load.index["b.jpg"]
+	if e.Hash != "sha256:bb" || e.Output != "b_min.png" || e.Options["b"] != "0.35" || e.Updated.IsZero() {
+		t.Errorf("loadManifest() entry error[%+v]", e)
+	}
+
+	//外部から読み込む形式
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile() Error[%v]", err)
+	}
+	var raw struct {
+		Version int                      `json:"version"`
+		Entries []map[string]interface{} `json:"entries"`
+	}
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		t.Fatalf("json.Unmarshal() Error[%v]", err)
+	}
+	if raw.Version != manifestVersion {
+		t.Errorf("manifest version[%d]", raw.Version)
+	}
+	for _, key := range []string{"input", "hash", "options", "output", "updated"} {
+		if _, ok := raw.Entries[0][key]; !ok {
+			t.Errorf("manifest key not found[%s]", key)
+		}
+	}
+
+	//一時ファイルは残さない
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 1 {
+		t.Errorf("manifest temp file remains[%v]", files)
+	}
+}
+
+func TestManifestVersion(t *testing.T) {
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "manifest.json")
+
+	err := os.WriteFile(name, []byte(`{"version":2,"entries":[]}`), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile() Error[%v]", err)
+	}
+	_, err = loadManifest(name)
+	if err == nil {
+		t.Errorf("loadManifest() version not error")
+	}
+
+	err = os.WriteFile(name, []byte(`{"version":`), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile() Error[%v]", err)
+	}
+	_, err = loadManifest(name)
+	if err == nil {
+		t.Errorf("loadManifest() format not error")
+	}
+}
+
+func TestManifestUpToDate(t *testing.T) {
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "a.jpg")
+	output := filepath.Join(dir, "a_min.png")
+	createFiles(t, dir, "a.jpg", "a_min.png")
+
+	now := time.Now()
+	setTime(t, input, now.Add(-time.Hour))
+	setTime(t, output, now)
+
+	hash, err := fileHash(input)
+	if err != nil {
+		t.Fatalf("fileHash() Error[%v]", err)
+	}
+	opts := map[string]string{"b": "0.35"}
+
+	m, err := loadManifest(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatalf("loadManifest() Error[%v]", err)
+	}
+	if m.upToDate(input, hash, output, opts) {
+		t.Errorf("upToDate() not recorded")
+	}
+
+	m.record(input, hash, output, opts)
+	if !m.upToDate(input, hash, output, opts) {
+		t.Errorf("upToDate() recorded")
+	}
+
+	tests := map[string]func() bool{
+		"hash":    func() bool { return m.upToDate(input, "sha256:00", output, opts) },
+		"options": func() bool { return m.upToDate(input, hash, output, map[string]string{"b": "0.3"}) },
+		"output":  func() bool { return m.upToDate(input, hash, output+".gif", opts) },
+	}
+	for name, fn := range tests {
+		if fn() {
+			t.Errorf("upToDate() %s changed", name)
+		}
+	}
+
+	//入力の方が新しい
+	setTime(t, input, now.Add(time.Hour))
+	if m.upToDate(input, hash, output, opts) {
+		t.Errorf("upToDate() input newer")
+	}
+	setTime(t, input, now.Add(-time.Hour))
+
+	//出力の削除
+	os.Remove(output)
+	if m.upToDate(input, hash, output, opts) {
+		t.Errorf("upToDate() output removed")
+	}
+}
+
+func TestRunIncremental(t *testing.T) {
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "broken.jpg")
+	err := os.WriteFile(input, []byte("not image"), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile() Error[%v]", err)
+	}
+
+	m, err := loadManifest(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatalf("loadManifest() Error[%v]", err)
+	}
+	m.record(input, "sha256:00", outputName(input), optionSet())
+
+	//失敗した場合は記録を削除
+	skip, err := runIncremental(input, noteshrink.DefaultOption(), m)
+	if err == nil || skip {
+		t.Fatalf("runIncremental() broken image [%v][%v]", skip, err)
+	}
+	if _, ok := m.index[input]; ok {
+		t.Errorf("runIncremental() failed entry remains")
+	}
+}
+
+func TestOptionSet(t *testing.T) {
+
+	opts := optionSet()
+	for name := range ignoreFlags {
+		if _, ok := opts[name]; ok {
+			t.Errorf("optionSet() ignore flag found[%s]", name)
+		}
+	}
+	for _, name := range []string{"b", "s", "f", "suffix", "format"} {
+		if _, ok := opts[name]; !ok {
+			t.Errorf("optionSet() flag not found[%s]", name)
+		}
+	}
+}
+
+//Test用のツール
+//ファイルの更新日時を変更
+func setTime(t *testing.T, f string, tm time.Time) {
+	t.Helper()
+	err := os.Chtimes(f, tm, tm)
+	if err != nil {
+		t.Fatalf("Chtimes() Error[%v]", err)
+	}
+}
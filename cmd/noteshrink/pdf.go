@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/shizuokago/noteshrink"
+)
+
+//PDF出力のオプション
+func pdfOption() (*noteshrink.PDFOption, error) {
+
+	op := noteshrink.DefaultPDFOption()
+	op.DPI = *dpiVal
+
+	switch strings.ToLower(*pageVal) {
+	case "":
+	case "a4":
+		op.PageWidth, op.PageHeight = noteshrink.A4Width, noteshrink.A4Height
+	case "letter":
+		op.PageWidth, op.PageHeight = noteshrink.LetterWidth, noteshrink.LetterHeight
+	default:
+		return nil, fmt.Errorf("page size not supported[%s]", *pageVal)
+	}
+	return op, nil
+}
+
+//PDFのページを引数の順序で書き込み
+//
+//変換の終わった結果は順番が来るまで保持し、書き込んだ時点で破棄します
+//wait() で window を超えて先のファイルを変換しない様にする為、保持する結果は window 以下になります
+type pdfPages struct {
+	name   string
+	out    *os.File
+	pw     *noteshrink.PDFWriter
+	window int
+
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	results  map[int]*noteshrink.Result
+	finished map[int]bool
+	next     int
+	pages    int
+	err      error
+}
+
+//PDFファイルを作成
+func newPDFPages(name string, window int) (*pdfPages, error) {
+
+	op, err := pdfOption()
+	if err != nil {
+		return nil, err
+	}
+	if window < 1 {
+		window = 1
+	}
+
+	out, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	pw, err := noteshrink.NewPDFWriter(out, op)
+	if err != nil {
+		out.Close()
+		os.Remove(name)
+		return nil, err
+	}
+
+	p := pdfPages{}
+	p.name = name
+	p.out = out
+	p.pw = pw
+	p.window = window
+	p.cond = sync.NewCond(&p.mutex)
+	p.results = make(map[int]*noteshrink.Result)
+	p.finished = make(map[int]bool)
+	return &p, nil
+}
+
+//idx のファイルを変換できるまで待機
+func (p *pdfPages) wait(idx int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for idx >= p.next+p.window {
+		p.cond.Wait()
+	}
+}
+
+//変換結果を追加し、順番の来たページを書き込み
+//
+//r がnilの場合は変換に失敗したファイルとしてページに含めません
+func (p *pdfPages) put(idx int, r *noteshrink.Result) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.finished[idx] = true
+	if r != nil {
+		p.results[idx] = r
+	}
+
+	for p.finished[p.next] {
+		if r, ok := p.results[p.next]; ok && p.err == nil {
+			p.err = p.pw.WritePage(r)
+			if p.err == nil {
+				p.pages++
+			}
+		}
+		delete(p.results, p.next)
+		delete(p.finished, p.next)
+		p.next++
+	}
+	p.cond.Broadcast()
+}
+
+//PDFを閉じる
+//
+//失敗した場合はファイルを削除します
+func (p *pdfPages) close() error {
+
+	err := p.err
+	if err == nil {
+		err = p.pw.Close()
+	}
+	cerr := p.out.Close()
+	if err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(p.name)
+		return err
+	}
+	log.Printf("Generated : [%s][%d pages]\n", p.name, p.pages)
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shizuokago/noteshrink"
+)
+
+func TestPDFPages(t *testing.T) {
+
+	img := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for i := range img.Pix {
+		img.Pix[i] = 240
+	}
+	img.SetRGBA(5, 5, color.RGBA{R: 20, G: 40, B: 200, A: 255})
+	r, err := noteshrink.ShrinkResult(img, nil)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+
+	name := filepath.Join(t.TempDir(), "pages.pdf")
+	pages, err := newPDFPages(name, 2)
+	if err != nil {
+		t.Fatalf("newPDFPages() Error[%v]", err)
+	}
+
+	//順番が来るまで保持
+	pages.wait(1)
+	pages.put(1, r)
+	if pages.pages != 0 || len(pages.results) != 1 {
+		t.Errorf("pdfPages.put() written before turn[%d]", pages.pages)
+	}
+
+	//window を超えるファイルは待機
+	waited := make(chan bool)
+	go func() {
+		pages.wait(2)
+		waited <- true
+	}()
+	select {
+	case <-waited:
+		t.Errorf("pdfPages.wait() not waited")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	//失敗したファイルは飛ばす
+	pages.put(0, nil)
+	<-waited
+	if pages.pages != 1 || len(pages.results) != 0 {
+		t.Errorf("pdfPages.put() pages[%d] results[%d]", pages.pages, len(pages.results))
+	}
+	pages.put(2, r)
+
+	err = pages.close()
+	if err != nil {
+		t.Fatalf("pdfPages.close() Error[%v]", err)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile() Error[%v]", err)
+	}
+	if n := bytes.Count(data, []byte("/Type /Page ")); n != 2 {
+		t.Errorf("PDF page count[%d]", n)
+	}
+
+	//ページがない場合は削除
+	pages, err = newPDFPages(name, 1)
+	if err != nil {
+		t.Fatalf("newPDFPages() Error[%v]", err)
+	}
+	pages.put(0, nil)
+	err = pages.close()
+	if err == nil {
+		t.Errorf("pdfPages.close() no page not error")
+	}
+	if _, err := os.Stat(name); err == nil {
+		t.Errorf("pdfPages.close() file remains")
+	}
+
+	setFlag(t, pageVal, "b5")
+	_, err = newPDFPages(name, 1)
+	if err == nil {
+		t.Errorf("newPDFPages() page size not error")
+	}
+}
@@ -0,0 +1,217 @@
+package noteshrink
+
+import (
+	"math"
+	"sort"
+)
+
+//背景として扱うブロック内の明るい画素の割合
+const flattenPercentile = 0.1
+
+//照明ムラの補正
+//
+//ブロックごとに明るい画素から背景を推定し、平滑化した背景で割ることで
+//画像全体の背景を背景の平均色に揃えます
+func flatten(p *pixelBuffer, size int) *pixelBuffer {
+
+	if p.len() == 0 {
+		return p
+	}
+
+	if size <= 0 {
+		size = p.cols
+		if p.rows < size {
+			size = p.rows
+		}
+		size /= 16
+	}
+	if size < 4 {
+		size = 4
+	}
+
+	field := estimateField(p, size)
+	smoothField(field)
+
+	//背景の平均色
+	var target [3]float64
+	for _, f := range field.v {
+		for c := 0; c < 3; c++ {
+			target[c] += f[c]
+		}
+	}
+	for c := 0; c < 3; c++ {
+		target[c] /= float64(len(field.v))
+	}
+
+	rtn := newPixelBuffer(p.cols, p.rows)
+	for y := 0; y < p.rows; y++ {
+		for x := 0; x < p.cols; x++ {
+			idx := y*p.cols + x
+			f := field.at(x, y)
+			r, g, b := p.rgb(idx)
+			rtn.pix[idx] = packRGB(
+				normalize(r, f[0], target[0]),
+				normalize(g, f[1], target[1]),
+				normalize(b, f[2], target[2]))
+		}
+	}
+	return rtn
+}
+
+//背景色と比率を揃える
+func normalize(v uint8, f, target float64) uint8 {
+	if f < 1 {
+		f = 1
+	}
+	return uint8(math.Round(clamp(float64(v)*target/f, 0, 255)))
+}
+
+//ブロックごとの背景
+type bgField struct {
+	size int
+	cols int
+	rows int
+	v    [][3]float64
+}
+
+//ブロックごとに明るい画素の平均色を背景として推定
+func estimateField(p *pixelBuffer, size int) *bgField {
+
+	f := bgField{size: size}
+	f.cols = (p.cols + size - 1) / size
+	f.rows = (p.rows + size - 1) / size
+	f.v = make([][3]float64, f.cols*f.rows)
+
+	var hist [256]int
+	for by := 0; by < f.rows; by++ {
+		for bx := 0; bx < f.cols; bx++ {
+
+			x0, y0 := bx*size, by*size
+			x1, y1 := minInt(x0+size, p.cols), minInt(y0+size, p.rows)
+
+			//明るさ(RGBの最大値)の分布
+			for i := range hist {
+				hist[i] = 0
+			}
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					hist[maxRGB(p.rgb(y*p.cols+x))]++
+				}
+			}
+
+			num := int(float64((x1-x0)*(y1-y0)) * flattenPercentile)
+			if num < 1 {
+				num = 1
+			}
+			level := 255
+			for count := hist[level]; count < num && level > 0; count += hist[level] {
+				level--
+			}
+
+			//閾値以上の画素の平均
+			var sum [3]float64
+			n := 0
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					r, g, b := p.rgb(y*p.cols + x)
+					if maxRGB(r, g, b) >= uint8(level) {
+						sum[0] += float64(r)
+						sum[1] += float64(g)
+						sum[2] += float64(b)
+						n++
+					}
+				}
+			}
+			for c := 0; c < 3; c++ {
+				f.v[by*f.cols+bx][c] = sum[c] / float64(n)
+			}
+		}
+	}
+	return &f
+}
+
+//3x3のメディアンで平滑化
+//
+//インクの多いブロックを周りの背景で置き換えます
+func smoothField(f *bgField) {
+
+	v := make([][3]float64, len(f.v))
+	var window [9]float64
+	for by := 0; by < f.rows; by++ {
+		for bx := 0; bx < f.cols; bx++ {
+			for c := 0; c < 3; c++ {
+				i := 0
+				for dy := -1; dy <= 1; dy++ {
+					for dx := -1; dx <= 1; dx++ {
+						window[i] = f.get(bx+dx, by+dy, c)
+						i++
+					}
+				}
+				sort.Float64s(window[:])
+				v[by*f.cols+bx][c] = window[4]
+			}
+		}
+	}
+	f.v = v
+}
+
+//ブロックの背景を取得
+//
+//範囲外は端の傾きで外挿します
+func (f *bgField) get(x, y, c int) float64 {
+	switch {
+	case x < 0 && f.cols > 1:
+		return 2*f.get(0, y, c) - f.get(1, y, c)
+	case x >= f.cols && f.cols > 1:
+		return 2*f.get(f.cols-1, y, c) - f.get(f.cols-2, y, c)
+	case y < 0 && f.rows > 1:
+		return 2*f.get(x, 0, c) - f.get(x, 1, c)
+	case y >= f.rows && f.rows > 1:
+		return 2*f.get(x, f.rows-1, c) - f.get(x, f.rows-2, c)
+	}
+	x = minInt(maxInt(x, 0), f.cols-1)
+	y = minInt(maxInt(y, 0), f.rows-1)
+	return f.v[y*f.cols+x][c]
+}
+
+//画素位置の背景をブロックの中心から双線形補間
+func (f *bgField) at(x, y int) [3]float64 {
+
+	fx := (float64(x)+0.5)/float64(f.size) - 0.5
+	fy := (float64(y)+0.5)/float64(f.size) - 0.5
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	ax, ay := fx-float64(x0), fy-float64(y0)
+
+	var rtn [3]float64
+	for c := 0; c < 3; c++ {
+		top := f.get(x0, y0, c)*(1-ax) + f.get(x0+1, y0, c)*ax
+		bottom := f.get(x0, y0+1, c)*(1-ax) + f.get(x0+1, y0+1, c)*ax
+		rtn[c] = top*(1-ay) + bottom*ay
+	}
+	return rtn
+}
+
+//RGBの最大値(HSVのV)
+func maxRGB(r, g, b uint8) uint8 {
+	if g > r {
+		r = g
+	}
+	if b > r {
+		r = b
+	}
+	return r
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
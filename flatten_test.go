@@ -0,0 +1,110 @@
+package noteshrink
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFlatten(t *testing.T) {
+
+	img := createVignette(160, 120)
+	data, err := convertPixels(img)
+	if err != nil {
+		t.Fatalf("convertPixels() Error[%v]", err)
+	}
+
+	flat := flatten(data, 0)
+	if flat.cols != data.cols || flat.rows != data.rows {
+		t.Fatalf("flatten() size [%dx%d]", flat.cols, flat.rows)
+	}
+
+	//紙の部分はほぼ同じ明るさになる
+	min, max := uint8(255), uint8(0)
+	for idx := range flat.pix {
+		if isVignetteInk(idx%160, idx/160) {
+			continue
+		}
+		r, _, _ := flat.rgb(idx)
+		if r < min {
+			min = r
+		}
+		if r > max {
+			max = r
+		}
+	}
+	if int(max)-int(min) > 20 {
+		t.Errorf("flatten() paper range [%d-%d]", min, max)
+	}
+
+	//インクは暗いまま
+	r, _, _ := flat.rgb(60*160 + 80)
+	if r > 60 {
+		t.Errorf("flatten() ink [%d]", r)
+	}
+
+	//一様な画像は変わらない
+	gray := newPixelBuffer(40, 30)
+	for idx := range gray.pix {
+		gray.pix[idx] = packRGB(200, 210, 220)
+	}
+	flat = flatten(gray, 8)
+	for idx := range flat.pix {
+		if flat.pix[idx] != gray.pix[idx] {
+			t.Fatalf("flatten() uniform image changed[%d][%06x]", idx, flat.pix[idx])
+		}
+	}
+}
+
+func TestShrinkFlatten(t *testing.T) {
+
+	img := createVignette(160, 120)
+
+	op := DefaultOption()
+	op.SamplingRate = 0.1
+	r, err := ShrinkResult(img, op)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+	if !r.Mask[0] {
+		t.Errorf("ShrinkResult() dark corner not foreground")
+	}
+
+	op.Flatten = true
+	r, err = ShrinkResult(img, op)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+
+	for idx, m := range r.Mask {
+		x, y := idx%160, idx/160
+		if m != isVignetteInk(x, y) {
+			t.Fatalf("ShrinkResult() Flatten mask [%d,%d] %v", x, y, m)
+		}
+	}
+}
+
+//Test用のツール
+//四隅ほど暗い紙に横線を引いた画像を作成
+func createVignette(cols, rows int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, cols, rows))
+	cx, cy := float64(cols)/2, float64(rows)/2
+	max := cx*cx + cy*cy
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			dx, dy := float64(x)-cx, float64(y)-cy
+			k := 1.0 - 0.45*(dx*dx+dy*dy)/max
+			c := color.RGBA{R: uint8(240 * k), G: uint8(236 * k), B: uint8(228 * k), A: 255}
+			if isVignetteInk(x, y) {
+				c = color.RGBA{R: uint8(30 * k), G: uint8(30 * k), B: uint8(40 * k), A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+//横線の位置
+func isVignetteInk(x, y int) bool {
+	return y%20 == 0 && x >= 10 && x < 150
+}
@@ -5,55 +5,134 @@ import (
 	"image"
 	"image/color"
 	"image/gif"
+	_ "image/jpeg"
 	"image/png"
+	"io"
 	"math"
 	"os"
 )
 
-//PNG の圧縮出力
-func OutputPNG(f string, img image.Image) error {
-	//出力ファイルの作成
-	out, err := os.Create(f)
+//画像の読み込み
+//
+//PNG,JPEG,GIFに対応しています。戻り値の文字列は形式名(png,jpeg,gif)です
+func Decode(r io.Reader) (image.Image, string, error) {
+	return image.Decode(r)
+}
+
+//ファイルから画像を読み込み
+func DecodeFile(f string) (image.Image, error) {
+
+	file, err := os.Open(f)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer out.Close()
+	defer file.Close()
 
+	img, _, err := Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+//PNG の圧縮書き込み
+func EncodePNG(w io.Writer, img image.Image) error {
 	var enc png.Encoder
 	enc.CompressionLevel = png.BestCompression
-	return enc.Encode(out, img)
+	return enc.Encode(w, img)
 }
 
-var gifPalette color.Palette = nil
+//減色したGIFパレットでの書き込み
+//
+//パレットは画像から作成する為、Shrink()の結果以外を渡すとエラーになる場合があります
+func EncodeGIF(w io.Writer, img image.Image) error {
+
+	p, err := imagePalette(img)
+	if err != nil {
+		return err
+	}
+
+	if len(p) == 0 || len(p) > 256 {
+		return fmt.Errorf("palette length error[%d]", len(p))
+	}
 
-//減色GIFのパレット作成
-func setGIFPalette(bg *Pixel, fore Pixels) {
-	gifPalette = make(color.Palette, len(fore)+1)
-	gifPalette[0] = bg.Color()
-	for i, pix := range fore {
-		gifPalette[i+1] = pix.Color()
+	op := &gif.Options{
+		NumColors: len(p),
+		Quantizer: NewQuantizer(p),
 	}
+	return gif.Encode(w, img, op)
+}
+
+//PNG の圧縮出力
+func OutputPNG(f string, img image.Image) error {
+	return outputFile(f, func(w io.Writer) error {
+		return EncodePNG(w, img)
+	})
 }
 
 //減色したGIFパレットでの出力
+//
+//パレットは画像から作成する為、Shrink()の結果以外を渡すとエラーになる場合があります
 func OutputGIF(f string, img image.Image) error {
+	return outputFile(f, func(w io.Writer) error {
+		return EncodeGIF(w, img)
+	})
+}
 
-	if gifPalette == nil {
-		return fmt.Errorf("palette is nil")
-	}
+//Shrinkの結果をPNGで出力
+func OutputResultPNG(f string, r *Result) error {
+	return OutputPNG(f, r.Image)
+}
+
+//Shrinkの結果をGIFで出力
+func OutputResultGIF(f string, r *Result) error {
+	return OutputGIF(f, r.Image)
+}
+
+//ファイルを作成して書き込み
+//
+//書き込みに失敗した場合もファイルは閉じます
+func outputFile(f string, fn func(w io.Writer) error) error {
 
 	//出力ファイルの作成
 	out, err := os.Create(f)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
 
-	op := &gif.Options{
-		NumColors: len(gifPalette),
-		Quantizer: NewQuantizer(gifPalette),
+	err = fn(out)
+	if err != nil {
+		out.Close()
+		return err
 	}
-	return gif.Encode(out, img, op)
+	return out.Close()
+}
+
+//画像で使用している色からパレットを作成
+func imagePalette(img image.Image) (color.Palette, error) {
+
+	if p, ok := img.(*image.Paletted); ok {
+		return p.Palette, nil
+	}
+
+	rtn := make(color.Palette, 0, 256)
+	exists := make(map[color.RGBA]bool)
+
+	rect := img.Bounds()
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			if exists[c] {
+				continue
+			}
+			if len(rtn) >= 256 {
+				return nil, fmt.Errorf("image has more than 256 colors")
+			}
+			exists[c] = true
+			rtn = append(rtn, c)
+		}
+	}
+	return rtn, nil
 }
 
 //減色GIFのQuantazer
@@ -74,27 +153,216 @@ func (q gifQuantizer) Quantize(p color.Palette, img image.Image) color.Palette {
 }
 
 //ConvertGridにより、image.ImageをGridに展開します
-func convertPixels(img image.Image) (Pixels, error) {
+//
+//標準パッケージの画像は画素データを直接読み込みます
+func convertPixels(img image.Image) (*pixelBuffer, error) {
+
+	switch src := img.(type) {
+	case *image.YCbCr:
+		return convertYCbCr(src), nil
+	case *image.RGBA:
+		return convertRGBA(src), nil
+	case *image.NRGBA:
+		return convertNRGBA(src), nil
+	case *image.Gray:
+		return convertGray(src), nil
+	case *image.Gray16:
+		return convertGray16(src), nil
+	case *image.RGBA64:
+		return convertRGBA64(src), nil
+	case *image.CMYK:
+		return convertCMYK(src), nil
+	case *image.Paletted:
+		return convertPaletted(src)
+	}
+	return convertAt(img)
+}
+
+//At()による展開
+func convertAt(img image.Image) (*pixelBuffer, error) {
 
 	rect := img.Bounds()
-	cols := rect.Dx()
-	rows := rect.Dy()
+	rtn := newPixelBuffer(rect.Dx(), rect.Dy())
+	idx := 0
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c, err := convertColor(img.At(x, y))
+			if err != nil {
+				return nil, err
+			}
+			rtn.pix[idx] = packRGB(c.R, c.G, c.B)
+			idx++
+		}
+	}
+	return rtn, nil
+}
+
+//JPEG
+func convertYCbCr(img *image.YCbCr) *pixelBuffer {
 
-	rtn := make(Pixels, cols*rows)
+	rect := img.Rect
+	rtn := newPixelBuffer(rect.Dx(), rect.Dy())
 	idx := 0
 
-	for col := 0; col < cols; col++ {
-		for row := 0; row < rows; row++ {
-			color := img.At(col, row)
-			rtn[idx] = NewPixel(color)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			yi := img.YOffset(x, y)
+			ci := img.COffset(x, y)
+			r, g, b := color.YCbCrToRGB(img.Y[yi], img.Cb[ci], img.Cr[ci])
+			rtn.pix[idx] = packRGB(r, g, b)
 			idx++
 		}
 	}
+	return rtn
+}
+
+func convertRGBA(img *image.RGBA) *pixelBuffer {
+
+	rect := img.Rect
+	rtn := newPixelBuffer(rect.Dx(), rect.Dy())
+	idx := 0
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		i := img.PixOffset(rect.Min.X, y)
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			rtn.pix[idx] = packRGB(img.Pix[i], img.Pix[i+1], img.Pix[i+2])
+			i += 4
+			idx++
+		}
+	}
+	return rtn
+}
+
+//PNG
+//
+//color.RGBAModel と同じく、アルファを乗算します
+func convertNRGBA(img *image.NRGBA) *pixelBuffer {
 
+	rect := img.Rect
+	rtn := newPixelBuffer(rect.Dx(), rect.Dy())
+	idx := 0
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		i := img.PixOffset(rect.Min.X, y)
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			a := uint32(img.Pix[i+3])
+			r := uint32(img.Pix[i]) * 0x101 * a / 0xff
+			g := uint32(img.Pix[i+1]) * 0x101 * a / 0xff
+			b := uint32(img.Pix[i+2]) * 0x101 * a / 0xff
+			rtn.pix[idx] = packRGB(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+			i += 4
+			idx++
+		}
+	}
+	return rtn
+}
+
+func convertGray(img *image.Gray) *pixelBuffer {
+
+	rect := img.Rect
+	rtn := newPixelBuffer(rect.Dx(), rect.Dy())
+	idx := 0
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		i := img.PixOffset(rect.Min.X, y)
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			v := img.Pix[i]
+			rtn.pix[idx] = packRGB(v, v, v)
+			i++
+			idx++
+		}
+	}
+	return rtn
+}
+
+//16bitは上位8bitを利用
+func convertGray16(img *image.Gray16) *pixelBuffer {
+
+	rect := img.Rect
+	rtn := newPixelBuffer(rect.Dx(), rect.Dy())
+	idx := 0
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		i := img.PixOffset(rect.Min.X, y)
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			v := img.Pix[i]
+			rtn.pix[idx] = packRGB(v, v, v)
+			i += 2
+			idx++
+		}
+	}
+	return rtn
+}
+
+//16bitは上位8bitを利用
+func convertRGBA64(img *image.RGBA64) *pixelBuffer {
+
+	rect := img.Rect
+	rtn := newPixelBuffer(rect.Dx(), rect.Dy())
+	idx := 0
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		i := img.PixOffset(rect.Min.X, y)
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			rtn.pix[idx] = packRGB(img.Pix[i], img.Pix[i+2], img.Pix[i+4])
+			i += 8
+			idx++
+		}
+	}
+	return rtn
+}
+
+func convertCMYK(img *image.CMYK) *pixelBuffer {
+
+	rect := img.Rect
+	rtn := newPixelBuffer(rect.Dx(), rect.Dy())
+	idx := 0
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		i := img.PixOffset(rect.Min.X, y)
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			r, g, b := color.CMYKToRGB(img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3])
+			rtn.pix[idx] = packRGB(r, g, b)
+			i += 4
+			idx++
+		}
+	}
+	return rtn
+}
+
+//パレットの色を先に変換
+func convertPaletted(img *image.Paletted) (*pixelBuffer, error) {
+
+	//インデックスは uint8 の為、257色目以降は利用されない
+	pal := img.Palette[:minInt(len(img.Palette), 256)]
+	table := make([]uint32, 256)
+	for i, c := range pal {
+		cc, err := convertColor(c)
+		if err != nil {
+			return nil, err
+		}
+		table[i] = packRGB(cc.R, cc.G, cc.B)
+	}
+
+	rect := img.Rect
+	rtn := newPixelBuffer(rect.Dx(), rect.Dy())
+	idx := 0
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		i := img.PixOffset(rect.Min.X, y)
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			rtn.pix[idx] = table[img.Pix[i]]
+			i++
+			idx++
+		}
+	}
 	return rtn, nil
 }
 
 //colorのキャスト
+//
+//YCbCr,RGBA以外は color.RGBAModel で変換します
 func convertColor(c color.Color) (*color.RGBA, error) {
 	switch c.(type) {
 	case color.YCbCr:
@@ -107,9 +375,12 @@ func convertColor(c color.Color) (*color.RGBA, error) {
 	case *color.RGBA:
 		newColor := c.(*color.RGBA)
 		return newColor, nil
+	case nil:
+		return nil, fmt.Errorf("not support color[%v]", c)
 	default:
 	}
-	return nil, fmt.Errorf("not support color[%v]", c)
+	newColor := color.RGBAModel.Convert(c).(color.RGBA)
+	return &newColor, nil
 }
 
 //https://www.rapidtables.com/convert/color/rgb-to-hsv.html
@@ -189,6 +460,181 @@ func HSV2RGB(h, s, v float64) *color.RGBA {
 	return FloatRGBA(r*255.0, g*255.0, b*255.0)
 }
 
+//ColorSpace は色の距離を測る色空間です
+type ColorSpace int
+
+const (
+	//RGB はsRGBの値をそのまま利用します
+	RGB ColorSpace = iota
+	//CIELAB はD65を白色点にしたL*a*b*です
+	CIELAB
+	//OKLab は https://bottosson.github.io/posts/oklab/ です
+	OKLab
+)
+
+//色空間の値に変換
+func (cs ColorSpace) vector(r, g, b uint8) [3]float64 {
+	switch cs {
+	case CIELAB:
+		l, a, bb := RGB2Lab(r, g, b)
+		return [3]float64{l, a, bb}
+	case OKLab:
+		l, a, bb := RGB2OKLab(r, g, b)
+		return [3]float64{l, a, bb}
+	}
+	return [3]float64{float64(r), float64(g), float64(b)}
+}
+
+//色空間の値からPixelを作成
+func (cs ColorSpace) pixel(v [3]float64) *Pixel {
+	var c *color.RGBA
+	switch cs {
+	case CIELAB:
+		c = Lab2RGB(v[0], v[1], v[2])
+	case OKLab:
+		c = OKLab2RGB(v[0], v[1], v[2])
+	default:
+		c = FloatRGBA(clamp(v[0], 0, 255), clamp(v[1], 0, 255), clamp(v[2], 0, 255))
+	}
+	return NewPixelRGB(c.R, c.G, c.B)
+}
+
+func (cs ColorSpace) String() string {
+	switch cs {
+	case RGB:
+		return "RGB"
+	case CIELAB:
+		return "CIELAB"
+	case OKLab:
+		return "OKLab"
+	}
+	return fmt.Sprintf("ColorSpace(%d)", int(cs))
+}
+
+//D65の白色点
+const (
+	whiteX = 0.95047
+	whiteY = 1.0
+	whiteZ = 1.08883
+)
+
+//https://en.wikipedia.org/wiki/CIELAB_color_space
+func RGB2Lab(or, og, ob uint8) (float64, float64, float64) {
+
+	r := linearize(or)
+	g := linearize(og)
+	b := linearize(ob)
+
+	x := (0.4124564*r + 0.3575761*g + 0.1804375*b) / whiteX
+	y := (0.2126729*r + 0.7151522*g + 0.0721750*b) / whiteY
+	z := (0.0193339*r + 0.1191920*g + 0.9503041*b) / whiteZ
+
+	fx := labF(x)
+	fy := labF(y)
+	fz := labF(z)
+
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+//https://en.wikipedia.org/wiki/CIELAB_color_space
+func Lab2RGB(l, a, b float64) *color.RGBA {
+
+	fy := (l + 16) / 116
+	fx := fy + a/500
+	fz := fy - b/200
+
+	x := labFInv(fx) * whiteX
+	y := labFInv(fy) * whiteY
+	z := labFInv(fz) * whiteZ
+
+	r := 3.2404542*x - 1.5371385*y - 0.4985314*z
+	g := -0.9692660*x + 1.8760108*y + 0.0415560*z
+	bb := 0.0556434*x - 0.2040259*y + 1.0572252*z
+
+	return FloatRGBA(delinearize(r), delinearize(g), delinearize(bb))
+}
+
+//https://bottosson.github.io/posts/oklab/
+func RGB2OKLab(or, og, ob uint8) (float64, float64, float64) {
+
+	r := linearize(or)
+	g := linearize(og)
+	b := linearize(ob)
+
+	l := math.Cbrt(0.4122214708*r + 0.5363325363*g + 0.0514459929*b)
+	m := math.Cbrt(0.2119034982*r + 0.6806995451*g + 0.1073969566*b)
+	s := math.Cbrt(0.0883024619*r + 0.2817188376*g + 0.6299787005*b)
+
+	return 0.2104542553*l + 0.7936177850*m - 0.0040720468*s,
+		1.9779984951*l - 2.4285922050*m + 0.4505937099*s,
+		0.0259040371*l + 0.7827717662*m - 0.8086757660*s
+}
+
+//https://bottosson.github.io/posts/oklab/
+func OKLab2RGB(l, a, b float64) *color.RGBA {
+
+	lc := l + 0.3963377774*a + 0.2158037573*b
+	mc := l - 0.1055613458*a - 0.0638541728*b
+	sc := l - 0.0894841775*a - 1.2914855480*b
+
+	lc = lc * lc * lc
+	mc = mc * mc * mc
+	sc = sc * sc * sc
+
+	r := 4.0767416621*lc - 3.3077115913*mc + 0.2309699292*sc
+	g := -1.2684380046*lc + 2.6097574011*mc - 0.3413193965*sc
+	bb := -0.0041960863*lc - 0.7034186147*mc + 1.7076147010*sc
+
+	return FloatRGBA(delinearize(r), delinearize(g), delinearize(bb))
+}
+
+//sRGBからリニアRGB(0-1)
+func linearize(v uint8) float64 {
+	c := float64(v) / 255.0
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+//リニアRGBからsRGB(0-255)
+func delinearize(c float64) float64 {
+	c = clamp(c, 0, 1)
+	if c <= 0.0031308 {
+		c = c * 12.92
+	} else {
+		c = 1.055*math.Pow(c, 1/2.4) - 0.055
+	}
+	return clamp(c*255.0, 0, 255)
+}
+
+func labF(t float64) float64 {
+	const delta = 6.0 / 29.0
+	if t > delta*delta*delta {
+		return math.Cbrt(t)
+	}
+	return t/(3*delta*delta) + 4.0/29.0
+}
+
+func labFInv(t float64) float64 {
+	const delta = 6.0 / 29.0
+	if t > delta {
+		return t * t * t
+	}
+	return 3 * delta * delta * (t - 4.0/29.0)
+}
+
+//範囲に収める
+func clamp(v, min, max float64) float64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
 //FloatのRGB値からRGBAの作成
 func FloatRGBA(r, g, b float64) *color.RGBA {
 
@@ -1,7 +1,13 @@
 package noteshrink
 
 import (
+	"bytes"
+	"image"
 	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -189,6 +195,241 @@ func TestConvert(t *testing.T) {
 	}
 }
 
+func TestRGB2Lab(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		r, g, b  uint8
+		l, a, bb float64
+	}{
+		{"Black", 0, 0, 0, 0, 0, 0},
+		{"White", 255, 255, 255, 100, 0, 0},
+		{"Red", 255, 0, 0, 53.2408, 80.0925, 67.2032},
+		{"Lime", 0, 255, 0, 87.7347, -86.1827, 83.1793},
+		{"Blue", 0, 0, 255, 32.2970, 79.1875, -107.8602},
+		{"Gray", 128, 128, 128, 53.5850, 0, 0},
+	}
+
+	for _, test := range tests {
+		l, a, b := RGB2Lab(test.r, test.g, test.b)
+		if !near(l, test.l, 0.01) || !near(a, test.a, 0.01) || !near(b, test.bb, 0.01) {
+			t.Errorf("Error:RGB2Lab %s value L[%f]a[%f]b[%f]", test.name, l, a, b)
+		}
+
+		c := Lab2RGB(l, a, b)
+		if c.R != test.r || c.G != test.g || c.B != test.b {
+			t.Errorf("Error:Lab2RGB %s value[%v]", test.name, c)
+		}
+	}
+
+	//範囲外は丸める
+	c := Lab2RGB(50, 200, -200)
+	if c.A != 255 {
+		t.Errorf("Error:Lab2RGB out of gamut[%v]", c)
+	}
+}
+
+func TestRGB2OKLab(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		r, g, b  uint8
+		l, a, bb float64
+	}{
+		{"Black", 0, 0, 0, 0, 0, 0},
+		{"White", 255, 255, 255, 1.0, 0, 0},
+		{"Red", 255, 0, 0, 0.627955, 0.224863, 0.125846},
+		{"Lime", 0, 255, 0, 0.866440, -0.233888, 0.179498},
+		{"Blue", 0, 0, 255, 0.452014, -0.032457, -0.311528},
+	}
+
+	for _, test := range tests {
+		l, a, b := RGB2OKLab(test.r, test.g, test.b)
+		if !near(l, test.l, 0.0001) || !near(a, test.a, 0.0001) || !near(b, test.bb, 0.0001) {
+			t.Errorf("Error:RGB2OKLab %s value L[%f]a[%f]b[%f]", test.name, l, a, b)
+		}
+
+		c := OKLab2RGB(l, a, b)
+		if c.R != test.r || c.G != test.g || c.B != test.b {
+			t.Errorf("Error:OKLab2RGB %s value[%v]", test.name, c)
+		}
+	}
+
+	//全色で往復できる
+	for r := 0; r < 256; r += 15 {
+		for g := 0; g < 256; g += 15 {
+			for b := 0; b < 256; b += 15 {
+				for _, space := range []ColorSpace{RGB, CIELAB, OKLab} {
+					p := space.pixel(space.vector(uint8(r), uint8(g), uint8(b)))
+					if int(p.R) != r || int(p.G) != g || int(p.B) != b {
+						t.Fatalf("Error:%v round trip[%d,%d,%d]!=[%v]", space, r, g, b, p)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestConvertPixels(t *testing.T) {
+
+	rect := image.Rect(3, 5, 40, 31)
+	rnd := rand.New(rand.NewSource(1))
+
+	fill := func(pix []uint8) {
+		for i := range pix {
+			pix[i] = uint8(rnd.Intn(256))
+		}
+	}
+
+	ycc := image.NewYCbCr(rect, image.YCbCrSubsampleRatio420)
+	fill(ycc.Y)
+	fill(ycc.Cb)
+	fill(ycc.Cr)
+
+	rgba := image.NewRGBA(rect)
+	fill(rgba.Pix)
+	nrgba := image.NewNRGBA(rect)
+	fill(nrgba.Pix)
+	gray := image.NewGray(rect)
+	fill(gray.Pix)
+	gray16 := image.NewGray16(rect)
+	fill(gray16.Pix)
+	rgba64 := image.NewRGBA64(rect)
+	fill(rgba64.Pix)
+	cmyk := image.NewCMYK(rect)
+	fill(cmyk.Pix)
+
+	pal := make(color.Palette, 16)
+	for i := range pal {
+		pal[i] = color.NRGBA{R: uint8(rnd.Intn(256)), G: uint8(rnd.Intn(256)), B: uint8(rnd.Intn(256)), A: 200}
+	}
+	paletted := image.NewPaletted(rect, pal)
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(rnd.Intn(len(pal)))
+	}
+
+	//256色を超えるパレット
+	large := make(color.Palette, 300)
+	for i := range large {
+		large[i] = color.RGBA{R: uint8(i), G: uint8(i / 2), B: 10, A: 255}
+	}
+	largePaletted := image.NewPaletted(rect, large)
+	for i := range largePaletted.Pix {
+		largePaletted.Pix[i] = uint8(rnd.Intn(256))
+	}
+
+	//SubImageでStrideとMinがずれた画像
+	sub := rgba.SubImage(image.Rect(10, 10, 20, 25))
+
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"YCbCr", ycc},
+		{"RGBA", rgba},
+		{"NRGBA", nrgba},
+		{"Gray", gray},
+		{"Gray16", gray16},
+		{"RGBA64", rgba64},
+		{"CMYK", cmyk},
+		{"Paletted", paletted},
+		{"Paletted300", largePaletted},
+		{"SubImage", sub},
+		{"NYCbCrA", image.NewNYCbCrA(rect, image.YCbCrSubsampleRatio444)},
+	}
+
+	//色が未設定の256色を超えるパレットでも panic しない
+	_, err := convertPixels(image.NewPaletted(rect, make(color.Palette, 300)))
+	if err == nil {
+		t.Errorf("convertPixels() nil color not error")
+	}
+
+	for _, test := range tests {
+
+		buf, err := convertPixels(test.img)
+		if err != nil {
+			t.Errorf("convertPixels() %s Error[%v]", test.name, err)
+			continue
+		}
+		expected, err := convertAt(test.img)
+		if err != nil {
+			t.Errorf("convertAt() %s Error[%v]", test.name, err)
+			continue
+		}
+
+		b := test.img.Bounds()
+		if buf.cols != b.Dx() || buf.rows != b.Dy() {
+			t.Errorf("convertPixels() %s size error[%d,%d]", test.name, buf.cols, buf.rows)
+			continue
+		}
+
+		for idx := range expected.pix {
+			if buf.pix[idx] != expected.pix[idx] {
+				t.Errorf("convertPixels() %s [%d] %06x!=%06x", test.name, idx, buf.pix[idx], expected.pix[idx])
+				break
+			}
+		}
+	}
+}
+
+func TestConvertColor(t *testing.T) {
+
+	tests := []struct {
+		c        color.Color
+		expected color.RGBA
+	}{
+		{color.RGBA{R: 1, G: 2, B: 3, A: 255}, color.RGBA{R: 1, G: 2, B: 3, A: 255}},
+		{color.NRGBA{R: 10, G: 20, B: 30, A: 255}, color.RGBA{R: 10, G: 20, B: 30, A: 255}},
+		{color.Gray{Y: 128}, color.RGBA{R: 128, G: 128, B: 128, A: 255}},
+		{color.Gray16{Y: 0xABCD}, color.RGBA{R: 0xAB, G: 0xAB, B: 0xAB, A: 255}},
+		{color.CMYK{C: 0, M: 255, Y: 255, K: 0}, color.RGBA{R: 255, G: 0, B: 0, A: 255}},
+	}
+
+	for _, test := range tests {
+		c, err := convertColor(test.c)
+		if err != nil {
+			t.Errorf("convertColor() Error[%v]", err)
+			continue
+		}
+		if *c != test.expected {
+			t.Errorf("convertColor() [%v] [%v]!=[%v]", test.c, *c, test.expected)
+		}
+
+		//NewPixelはnilにならない
+		if p := NewPixel(test.c); p == nil {
+			t.Errorf("NewPixel() nil [%v]", test.c)
+		}
+	}
+
+	_, err := convertColor(nil)
+	if err == nil {
+		t.Errorf("convertColor() nil not error")
+	}
+}
+
+func TestShrinkGray(t *testing.T) {
+
+	img := image.NewGray(image.Rect(0, 0, 60, 60))
+	for i := range img.Pix {
+		img.Pix[i] = 240
+	}
+	for y := 20; y < 40; y++ {
+		for x := 20; x < 40; x++ {
+			img.SetGray(x, y, color.Gray{Y: 20})
+		}
+	}
+
+	op := DefaultOption()
+	op.SamplingRate = 0.1
+	r, err := ShrinkResult(img, op)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+	if r.Image.ColorIndexAt(30, 30) == 0 {
+		t.Errorf("Foreground not found")
+	}
+}
+
 func BenchmarkImageAt(b *testing.B) {
 	img, err := loadImage("sample/notesA1.jpg")
 	if err != nil {
@@ -242,6 +483,10 @@ func BenchmarkUintRGBA(b *testing.B) {
 	}
 }
 
+func near(a, b, k float64) bool {
+	return a >= b-k && a <= b+k
+}
+
 func same(a, b float64) bool {
 	k := 0.00001
 	if a >= b-k && a <= b+k {
@@ -249,3 +494,93 @@ func same(a, b float64) bool {
 	}
 	return false
 }
+
+func TestEncodeDecode(t *testing.T) {
+
+	img := createNote(60, 40, color.RGBA{R: 20, G: 40, B: 200, A: 255})
+	op := DefaultOption()
+	op.SamplingRate = 0.1
+	r, err := ShrinkResult(img, op)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+
+	encoders := map[string]func(w *bytes.Buffer) error{
+		"png": func(w *bytes.Buffer) error { return EncodePNG(w, r.Image) },
+		"gif": func(w *bytes.Buffer) error { return EncodeGIF(w, r.Image) },
+	}
+
+	for name, enc := range encoders {
+		var buf bytes.Buffer
+		err = enc(&buf)
+		if err != nil {
+			t.Fatalf("Encode %s Error[%v]", name, err)
+		}
+		out, format, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("Decode() %s Error[%v]", name, err)
+		}
+		if format != name {
+			t.Errorf("Decode() format [%s]!=[%s]", format, name)
+		}
+		compareImage(t, name, r.Image, out)
+	}
+
+	//JPEGも読み込める
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, img, nil)
+	if err != nil {
+		t.Fatalf("jpeg.Encode() Error[%v]", err)
+	}
+	_, format, err := Decode(&buf)
+	if err != nil || format != "jpeg" {
+		t.Errorf("Decode() jpeg [%s] Error[%v]", format, err)
+	}
+
+	_, _, err = Decode(strings.NewReader("not image"))
+	if err == nil {
+		t.Errorf("Decode() not image not error")
+	}
+
+	//256色を超える画像はGIFにできない
+	err = EncodeGIF(&buf, createGradation(32, 32))
+	if err == nil {
+		t.Errorf("EncodeGIF() 256 over colors not error")
+	}
+}
+
+func TestOutputFile(t *testing.T) {
+
+	img := createNote(60, 40, color.RGBA{R: 200, G: 40, B: 20, A: 255})
+	r, err := ShrinkResult(img, nil)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+
+	dir := t.TempDir()
+	outputs := map[string]func(f string) error{
+		"a.png": func(f string) error { return OutputResultPNG(f, r) },
+		"a.gif": func(f string) error { return OutputResultGIF(f, r) },
+	}
+	for name, output := range outputs {
+		f := filepath.Join(dir, name)
+		err = output(f)
+		if err != nil {
+			t.Fatalf("Output %s Error[%v]", name, err)
+		}
+		out, err := DecodeFile(f)
+		if err != nil {
+			t.Fatalf("DecodeFile() %s Error[%v]", name, err)
+		}
+		compareImage(t, name, r.Image, out)
+	}
+
+	_, err = DecodeFile(filepath.Join(dir, "none.png"))
+	if err == nil {
+		t.Errorf("DecodeFile() not exists not error")
+	}
+	err = OutputPNG(filepath.Join(dir, "none", "a.png"), r.Image)
+	if err == nil {
+		t.Errorf("OutputPNG() not exists directory not error")
+	}
+}
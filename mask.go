@@ -0,0 +1,279 @@
+package noteshrink
+
+import (
+	"fmt"
+	"math"
+)
+
+//MaskMode は前景色の判定方法です
+type MaskMode int
+
+const (
+	//GlobalMask は画像全体で1つの背景色との距離で判定します
+	GlobalMask MaskMode = iota
+	//AdaptiveMask は画素の周囲の明るさ、彩度の統計で判定します(Sauvola法)
+	//照明ムラのある写真などで利用します
+	AdaptiveMask
+)
+
+func (m MaskMode) String() string {
+	switch m {
+	case GlobalMask:
+		return "GlobalMask"
+	case AdaptiveMask:
+		return "AdaptiveMask"
+	}
+	return fmt.Sprintf("MaskMode(%d)", int(m))
+}
+
+//AdaptiveMask の既定値
+const (
+	defaultSauvolaK = 0.2
+	minWindowSize   = 15
+)
+
+//Sauvola法の標準偏差の最大値(明るさ0-255の場合)
+const sauvolaR = 128.0
+
+//周囲の統計による前景色の判定
+//
+//明るさは Sauvola法の閾値より暗い画素、彩度は周囲の平均より Saturation 以上高い画素を前景色にします
+//明るい紙に暗いインクで書かれていることを前提にしています
+func adaptiveMask(p *pixelBuffer, op *Option) []bool {
+
+	rtn := make([]bool, p.len())
+	if p.len() == 0 {
+		return rtn
+	}
+
+	size := op.WindowSize
+	if size <= 0 {
+		size = minInt(p.cols, p.rows) / 16
+	}
+	size = maxInt(size, minWindowSize)
+	half := size / 2
+
+	k := op.SauvolaK
+	if k <= 0 {
+		k = defaultSauvolaK
+	}
+	ds := op.Saturation * 255
+
+	//明るさ、彩度(0-255)
+	v := make([]uint8, p.len())
+	s := make([]uint8, p.len())
+	rowBands(p.rows, op.Workers, func(y0, y1 int) {
+		for idx := y0 * p.cols; idx < y1*p.cols; idx++ {
+			r, g, b := unpackRGB(p.pix[idx])
+			max := maxRGB(r, g, b)
+			min := minRGB(r, g, b)
+			v[idx] = max
+			if max > 0 {
+				s[idx] = uint8(int(max-min) * 255 / int(max))
+			}
+		}
+	})
+
+	rowBands(p.rows, op.Workers, func(y0, y1 int) {
+
+		//窓の行方向の範囲の列ごとの合計
+		colV := make([]uint64, p.cols)
+		colV2 := make([]uint64, p.cols)
+		colS := make([]uint64, p.cols)
+		add := func(y int, sign int) {
+			for x := 0; x < p.cols; x++ {
+				i := y*p.cols + x
+				vv := uint64(v[i])
+				if sign > 0 {
+					colV[x] += vv
+					colV2[x] += vv * vv
+					colS[x] += uint64(s[i])
+				} else {
+					colV[x] -= vv
+					colV2[x] -= vv * vv
+					colS[x] -= uint64(s[i])
+				}
+			}
+		}
+
+		//列の合計の累積
+		sumV := make([]uint64, p.cols+1)
+		sumV2 := make([]uint64, p.cols+1)
+		sumS := make([]uint64, p.cols+1)
+
+		//開始行の前の行の窓
+		for y := maxInt(y0-1-half, 0); y < minInt(y0+half, p.rows); y++ {
+			add(y, 1)
+		}
+
+		for y := y0; y < y1; y++ {
+
+			//窓を1行ずらす
+			if y+half < p.rows {
+				add(y+half, 1)
+			}
+			if y-half-1 >= 0 {
+				add(y-half-1, -1)
+			}
+			h := minInt(y+half, p.rows-1) - maxInt(y-half, 0) + 1
+
+			for x := 0; x < p.cols; x++ {
+				sumV[x+1] = sumV[x] + colV[x]
+				sumV2[x+1] = sumV2[x] + colV2[x]
+				sumS[x+1] = sumS[x] + colS[x]
+			}
+
+			for x := 0; x < p.cols; x++ {
+
+				x0 := maxInt(x-half, 0)
+				x1 := minInt(x+half, p.cols-1) + 1
+				n := float64((x1 - x0) * h)
+
+				mv := float64(sumV[x1]-sumV[x0]) / n
+				mv2 := float64(sumV2[x1]-sumV2[x0]) / n
+				ms := float64(sumS[x1]-sumS[x0]) / n
+
+				sd := 0.0
+				if mv2 > mv*mv {
+					sd = math.Sqrt(mv2 - mv*mv)
+				}
+				th := mv * (1 + k*(sd/sauvolaR-1))
+
+				idx := y*p.cols + x
+				rtn[idx] = float64(v[idx]) < th || float64(s[idx])-ms >= ds
+			}
+		}
+	})
+	return rtn
+}
+
+//RGBの最小値
+func minRGB(r, g, b uint8) uint8 {
+	if g < r {
+		r = g
+	}
+	if b < r {
+		r = b
+	}
+	return r
+}
+
+//前景色の判定の後処理
+//
+//オープニング、クロージング、小さな領域の除去の順に行います
+func cleanMask(mask []bool, cols, rows int, op *Option) []bool {
+
+	if op.OpenRadius > 0 {
+		mask = erode(mask, cols, rows, op.OpenRadius)
+		mask = dilate(mask, cols, rows, op.OpenRadius)
+	}
+	if op.CloseRadius > 0 {
+		mask = dilate(mask, cols, rows, op.CloseRadius)
+		mask = erode(mask, cols, rows, op.CloseRadius)
+	}
+	if op.MinArea > 1 {
+		mask = removeSmallArea(mask, cols, rows, op.MinArea)
+	}
+	return mask
+}
+
+//膨張(一辺 2r+1 の正方形)
+func dilate(mask []bool, cols, rows, r int) []bool {
+	return morph(mask, cols, rows, r, false)
+}
+
+//収縮(一辺 2r+1 の正方形)
+//
+//画像の外側は前景色として扱い、端が削られないようにします
+func erode(mask []bool, cols, rows, r int) []bool {
+	return morph(mask, cols, rows, r, true)
+}
+
+//行方向、列方向の順に窓内の前景色を数えて判定
+//
+//all の場合は窓内が全て前景色、それ以外は1つでも前景色の場合に前景色にします
+func morph(mask []bool, cols, rows, r int, all bool) []bool {
+
+	tmp := make([]bool, len(mask))
+	rtn := make([]bool, len(mask))
+
+	line := func(src, dst []bool, start, step, leng int) {
+		count := 0
+		for i := 0; i < r && i < leng; i++ {
+			if src[start+i*step] {
+				count++
+			}
+		}
+		for i := 0; i < leng; i++ {
+			if in := i + r; in < leng && src[start+in*step] {
+				count++
+			}
+			if out := i - r - 1; out >= 0 && src[start+out*step] {
+				count--
+			}
+			if all {
+				n := minInt(i+r, leng-1) - maxInt(i-r, 0) + 1
+				dst[start+i*step] = count == n
+			} else {
+				dst[start+i*step] = count > 0
+			}
+		}
+	}
+
+	for y := 0; y < rows; y++ {
+		line(mask, tmp, y*cols, 1, cols)
+	}
+	for x := 0; x < cols; x++ {
+		line(tmp, rtn, x, cols, rows)
+	}
+	return rtn
+}
+
+//面積が min より小さい連結領域(8近傍)を除去
+func removeSmallArea(mask []bool, cols, rows, min int) []bool {
+
+	rtn := make([]bool, len(mask))
+	copy(rtn, mask)
+
+	visited := make([]bool, len(mask))
+	stack := make([]int, 0)
+	area := make([]int, 0)
+	for idx := range mask {
+
+		if !mask[idx] || visited[idx] {
+			continue
+		}
+
+		//領域を取得
+		area = area[:0]
+		stack = append(stack[:0], idx)
+		visited[idx] = true
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			area = append(area, i)
+
+			x, y := i%cols, i/cols
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || ny < 0 || nx >= cols || ny >= rows {
+						continue
+					}
+					n := ny*cols + nx
+					if mask[n] && !visited[n] {
+						visited[n] = true
+						stack = append(stack, n)
+					}
+				}
+			}
+		}
+
+		if len(area) < min {
+			for _, i := range area {
+				rtn[i] = false
+			}
+		}
+	}
+	return rtn
+}
@@ -0,0 +1,290 @@
+package noteshrink
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestAdaptiveMask(t *testing.T) {
+
+	img := createShadow(160, 120)
+	data, err := convertPixels(img)
+	if err != nil {
+		t.Fatalf("convertPixels() Error[%v]", err)
+	}
+
+	op := DefaultOption()
+	op.MaskMode = AdaptiveMask
+	mask := adaptiveMask(data, op)
+	for idx, m := range mask {
+		x, y := idx%160, idx/160
+		if m != isShadowInk(x, y) {
+			t.Fatalf("adaptiveMask() [%d,%d] %v", x, y, m)
+		}
+	}
+
+	//同じ明るさの色のインクは彩度で判定
+	red := color.RGBA{R: 230, G: 60, B: 60, A: 255}
+	note := createNote(80, 60, red)
+	data, err = convertPixels(note)
+	if err != nil {
+		t.Fatalf("convertPixels() Error[%v]", err)
+	}
+	op.WindowSize = 101
+	mask = adaptiveMask(data, op)
+	if !mask[30*80+40] {
+		t.Errorf("adaptiveMask() red ink is background")
+	}
+	if mask[0] {
+		t.Errorf("adaptiveMask() paper is foreground")
+	}
+}
+
+func TestShrinkAdaptiveMask(t *testing.T) {
+
+	img := createShadow(160, 120)
+
+	op := DefaultOption()
+	op.SamplingRate = 0.1
+	r, err := ShrinkResult(img, op)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+	shadow := 0
+	for idx, m := range r.Mask {
+		if m && !isShadowInk(idx%160, idx/160) {
+			shadow++
+		}
+	}
+	if shadow < len(r.Mask)/20 {
+		t.Errorf("ShrinkResult() GlobalMask shadow not foreground[%d]", shadow)
+	}
+
+	op.MaskMode = AdaptiveMask
+	r, err = ShrinkResult(img, op)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+	for idx, m := range r.Mask {
+		x, y := idx%160, idx/160
+		if m != isShadowInk(x, y) {
+			t.Fatalf("ShrinkResult() AdaptiveMask [%d,%d] %v", x, y, m)
+		}
+	}
+	for _, fg := range r.Foreground {
+		if fg.V > 0.5 {
+			t.Errorf("ShrinkResult() AdaptiveMask foreground is paper[%v]", fg)
+		}
+	}
+
+	rs, err := ShrinkSet([]image.Image{img, img}, op)
+	if err != nil {
+		t.Fatalf("ShrinkSet() Error[%v]", err)
+	}
+	if rs[1].Mask[60*160+150] {
+		t.Errorf("ShrinkSet() AdaptiveMask shadow is foreground")
+	}
+
+	//判定を再利用しても ShrinkPalette() と同じ結果
+	p, err := BuildPalette([]image.Image{img, img}, op)
+	if err != nil {
+		t.Fatalf("BuildPalette() Error[%v]", err)
+	}
+	r, err = ShrinkPalette(img, p, op)
+	if err != nil {
+		t.Fatalf("ShrinkPalette() Error[%v]", err)
+	}
+	for idx := range r.Labels {
+		if r.Labels[idx] != rs[1].Labels[idx] {
+			t.Fatalf("ShrinkSet() AdaptiveMask labels not same[%d]", idx)
+		}
+	}
+
+	op.AutoThreshold = true
+	_, err = ShrinkResult(img, op)
+	if err == nil {
+		t.Errorf("AdaptiveMask with AutoThreshold not error")
+	}
+
+	op.AutoThreshold = false
+	op.Hue = 0.1
+	_, err = ShrinkResult(img, op)
+	if err == nil {
+		t.Errorf("AdaptiveMask with Hue not error")
+	}
+
+	op.Hue = 0
+	op.MaskMode = MaskMode(5)
+	_, err = ShrinkResult(img, op)
+	if err == nil {
+		t.Errorf("MaskMode unknown not error")
+	}
+}
+
+func TestCleanMask(t *testing.T) {
+
+	src := []string{
+		"..............",
+		"..............",
+		"..#....###....",
+		".......#.#....",
+		".......###....",
+		"..............",
+		"..............",
+		"...........#..",
+		"##............",
+		"##............",
+	}
+
+	tests := []struct {
+		name     string
+		op       Option
+		expected []string
+	}{
+		{"none", Option{}, src},
+		{"area", Option{MinArea: 3}, []string{
+			"..............",
+			"..............",
+			".......###....",
+			".......#.#....",
+			".......###....",
+			"..............",
+			"..............",
+			"..............",
+			"##............",
+			"##............",
+		}},
+		{"close", Option{CloseRadius: 1}, []string{
+			"..............",
+			"..............",
+			"..#....###....",
+			".......###....",
+			".......###....",
+			"..............",
+			"..............",
+			"...........#..",
+			"##............",
+			"##............",
+		}},
+		{"open", Option{OpenRadius: 1}, []string{
+			"..............",
+			"..............",
+			"..............",
+			"..............",
+			"..............",
+			"..............",
+			"..............",
+			"..............",
+			"##............",
+			"##............",
+		}},
+		{"close area", Option{CloseRadius: 1, MinArea: 9}, []string{
+			"..............",
+			"..............",
+			".......###....",
+			".......###....",
+			".......###....",
+			"..............",
+			"..............",
+			"..............",
+			"..............",
+			"..............",
+		}},
+	}
+
+	for _, test := range tests {
+		mask, cols, rows := parseMask(src)
+		rtn := cleanMask(mask, cols, rows, &test.op)
+		expected, _, _ := parseMask(test.expected)
+		for idx := range rtn {
+			if rtn[idx] != expected[idx] {
+				t.Errorf("cleanMask() %s [%d,%d] %v", test.name, idx%cols, idx/cols, rtn[idx])
+			}
+		}
+	}
+}
+
+func TestShrinkCleanMask(t *testing.T) {
+
+	//インクの周りに点状のノイズ
+	img := createNote(100, 80, color.RGBA{R: 20, G: 40, B: 200, A: 255})
+	for i := 0; i < 40; i++ {
+		img.SetRGBA((i*37)%100, (i*11)%15, color.RGBA{R: 30, G: 30, B: 30, A: 255})
+	}
+
+	op := DefaultOption()
+	op.SamplingRate = 0.1
+	r, err := ShrinkResult(img, op)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+	if countMask(r.Mask) != 50*40+40 {
+		t.Errorf("ShrinkResult() mask count [%d]", countMask(r.Mask))
+	}
+
+	op.MinArea = 4
+	r, err = ShrinkResult(img, op)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+	if countMask(r.Mask) != 50*40 {
+		t.Errorf("ShrinkResult() MinArea mask count [%d]", countMask(r.Mask))
+	}
+	for idx, m := range r.Mask {
+		if !m && r.Labels[idx] != 0 {
+			t.Fatalf("ShrinkResult() MinArea label not background[%d]", idx)
+		}
+	}
+
+	op.MinArea = -1
+	_, err = ShrinkResult(img, op)
+	if err == nil {
+		t.Errorf("MinArea negative not error")
+	}
+}
+
+//Test用のツール
+//文字列("#"が前景色)からマスクを作成
+func parseMask(src []string) ([]bool, int, int) {
+	cols := len(src[0])
+	rtn := make([]bool, cols*len(src))
+	for y, line := range src {
+		for x, c := range line {
+			rtn[y*cols+x] = c == '#'
+		}
+	}
+	return rtn, cols, len(src)
+}
+
+func countMask(mask []bool) int {
+	n := 0
+	for _, m := range mask {
+		if m {
+			n++
+		}
+	}
+	return n
+}
+
+//Test用のツール
+//右に行くほど暗い紙に縦線を引いた画像を作成
+func createShadow(cols, rows int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, cols, rows))
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			k := 1.0 - 0.6*float64(x)/float64(cols)
+			c := color.RGBA{R: uint8(245 * k), G: uint8(242 * k), B: uint8(235 * k), A: 255}
+			if isShadowInk(x, y) {
+				c = color.RGBA{R: uint8(40 * k), G: uint8(40 * k), B: uint8(50 * k), A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+//縦線の位置
+func isShadowInk(x, y int) bool {
+	return x%16 == 8 && y >= 10 && y < 110
+}
@@ -0,0 +1,265 @@
+package noteshrink
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+)
+
+//Palette は背景色と前景色です
+type Palette struct {
+	Background *Pixel
+	Foreground Pixels
+
+	//Brightness,Saturation は前景色の判定に利用した閾値です
+	//Option.AutoThreshold の場合はサンプルから決定した値になります
+	Brightness float64
+	Saturation float64
+
+	//Warnings は色の選定時の警告です
+	Warnings []string
+}
+
+//選定結果からパレットを作成
+func newPalette(bg *Pixel, fg Pixels, op *Option) *Palette {
+
+	p := Palette{Background: bg, Foreground: fg}
+	p.Brightness = op.Brightness
+	p.Saturation = op.Saturation
+
+	k := op.ForegroundNum - 1
+	if len(fg) < k {
+		msg := fmt.Sprintf("foreground colors fewer than ForegroundNum-1[%d]<[%d]", len(fg), k)
+		p.Warnings = append(p.Warnings, msg)
+	}
+	return &p
+}
+
+//Colors は背景色を先頭にしたパレットを返します
+func (p *Palette) Colors() color.Palette {
+	rtn := make(color.Palette, len(p.Foreground)+1)
+	rtn[0] = *p.Background.Color()
+	for i, pix := range p.Foreground {
+		rtn[i+1] = *pix.Color()
+	}
+	return rtn
+}
+
+//適用時の閾値
+//
+//AutoThreshold の場合はパレット作成時に決定した閾値を利用します
+func (p *Palette) threshold(op *Option) *Option {
+
+	if !op.AutoThreshold || p.Brightness <= 0 || p.Saturation <= 0 {
+		return op
+	}
+
+	rtn := *op
+	rtn.Brightness = p.Brightness
+	rtn.Saturation = p.Saturation
+	return &rtn
+}
+
+//オプションに従い出力用の色に変換します
+func (p *Palette) adjust(op *Option) *Palette {
+
+	rtn := Palette{}
+	rtn.Background = p.Background
+	rtn.Foreground = p.Foreground
+	rtn.Brightness = p.Brightness
+	rtn.Saturation = p.Saturation
+
+	if op.Saturate {
+		rtn.Foreground = saturate(p.Background, p.Foreground)
+	}
+	if op.WhiteBackground {
+		rtn.Background = NewPixelRGB(255, 255, 255)
+	}
+	return &rtn
+}
+
+//前景色の彩度、明度を最大範囲まで広げます
+//
+//彩度は前景色の最大値が1になるように広げます
+//明度は背景色も含めた範囲で広げる為、背景色より明るい前景色にはなりません
+func saturate(bg *Pixel, fg Pixels) Pixels {
+
+	if len(fg) == 0 {
+		return fg
+	}
+
+	maxS := 0.0
+	minV, maxV := bg.V, bg.V
+	for _, pix := range fg {
+		maxS = math.Max(maxS, pix.S)
+		minV = math.Min(minV, pix.V)
+		maxV = math.Max(maxV, pix.V)
+	}
+
+	rtn := make(Pixels, len(fg))
+	for idx, pix := range fg {
+		s := pix.S
+		if maxS > 0 {
+			s = s / maxS
+		}
+		v := pix.V
+		if maxV > minV {
+			v = (v - minV) / (maxV - minV)
+		}
+		rtn[idx] = NewPixelHSV(pix.H, s, v)
+	}
+	return rtn
+}
+
+//PaletteBuilder は画像を1枚ずつ追加して共通のパレットを作成します
+//
+//追加した画像はサンプルのみを保持する為、全ページを同時に展開する必要はありません
+//複数の goroutine から同時に利用できません
+type PaletteBuilder struct {
+	op  *Option
+	rnd *rand.Rand
+
+	samples *pixelBuffer
+	bgp     *pixelBuffer
+	mask    []bool
+	num     int
+}
+
+//パレットの作成を開始します
+func NewPaletteBuilder(op *Option) (*PaletteBuilder, error) {
+
+	op, err := checkOption(op)
+	if err != nil {
+		return nil, err
+	}
+
+	b := PaletteBuilder{}
+	b.op = op
+	b.rnd = newRand(op)
+	b.samples = newPixelBuffer(0, 1)
+	b.bgp = newPixelBuffer(0, 1)
+	return &b, nil
+}
+
+//画像のサンプルを追加します
+//
+//同じ画像を同じ順序で追加した場合は BuildPalette() と同じ結果になります
+func (b *PaletteBuilder) Add(img image.Image) error {
+
+	//データの展開
+	data, err := preparePixels(img, b.op)
+	if err != nil {
+		return err
+	}
+	return b.add(data, imageMask(data, b.op))
+}
+
+//展開済の画像のサンプルを追加
+//
+//mask は imageMask() の結果です
+func (b *PaletteBuilder) add(data *pixelBuffer, mask []bool) error {
+
+	//画像ごとにサンプルを作成
+	num := int(float64(data.len()) * b.op.SamplingRate)
+	index, err := createSampleIndex(data.len(), num, b.rnd)
+	if err != nil {
+		return err
+	}
+	s := data.subset(index)
+	b.samples.pix = append(b.samples.pix, s.pix...)
+	b.bgp.pix = append(b.bgp.pix, backgroundPixels(data, s, b.op).pix...)
+	if m := sampleMask(mask, index); m != nil {
+		b.mask = append(b.mask, m...)
+	}
+	b.num++
+	return nil
+}
+
+//追加した画像のサンプルからパレットを作成します
+//
+//乱数を進める為、呼び出しは1回にしてください
+func (b *PaletteBuilder) Palette() (*Palette, error) {
+
+	if b.num == 0 {
+		return nil, fmt.Errorf("images length zero")
+	}
+
+	samples := &pixelBuffer{pix: b.samples.pix, cols: b.samples.len(), rows: 1}
+	bgp := &pixelBuffer{pix: b.bgp.pix, cols: b.bgp.len(), rows: 1}
+
+	//背景色を取得
+	bg, err := getBackgroundColor(bgp, b.op)
+	if err != nil {
+		return nil, err
+	}
+
+	//色の選定
+	return createPalette(samples, b.mask, bg, b.op, b.rnd)
+}
+
+//複数の画像からサンプルを抽出し、共通のパレットを作成します
+//
+//ノートなど同じ筆記具で書かれた複数ページの色を揃える場合に利用します
+//ページ数が多い場合は PaletteBuilder で1枚ずつ追加してください
+func BuildPalette(imgs []image.Image, op *Option) (*Palette, error) {
+
+	b, err := NewPaletteBuilder(op)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, img := range imgs {
+		err = b.Add(img)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Palette()
+}
+
+//複数の画像を共通のパレットで圧縮します
+//
+//結果は引数の画像と同じ順序で返します
+//AdaptiveMask の場合は画像ごとの判定をパレットの作成時に保持し、適用時に再利用します
+func ShrinkSet(imgs []image.Image, op *Option) ([]*Result, error) {
+
+	b, err := NewPaletteBuilder(op)
+	if err != nil {
+		return nil, err
+	}
+	op = b.op
+
+	masks := make([][]bool, len(imgs))
+	for idx, img := range imgs {
+		data, err := preparePixels(img, op)
+		if err != nil {
+			return nil, err
+		}
+		masks[idx] = imageMask(data, op)
+		err = b.add(data, masks[idx])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p, err := b.Palette()
+	if err != nil {
+		return nil, err
+	}
+
+	rtn := make([]*Result, len(imgs))
+	for idx, img := range imgs {
+		data, err := preparePixels(img, op)
+		if err != nil {
+			return nil, err
+		}
+		rtn[idx], err = applyPalette(data, masks[idx], p, op)
+		if err != nil {
+			return nil, err
+		}
+		masks[idx] = nil
+	}
+	return rtn, nil
+}
@@ -0,0 +1,305 @@
+package noteshrink
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestBuildPalette(t *testing.T) {
+
+	blue := color.RGBA{R: 20, G: 40, B: 200, A: 255}
+	red := color.RGBA{R: 200, G: 20, B: 20, A: 255}
+	imgs := []image.Image{
+		createNote(60, 60, blue),
+		createNote(60, 60, red),
+	}
+
+	op := DefaultOption()
+	op.SamplingRate = 0.1
+
+	p, err := BuildPalette(imgs, op)
+	if err != nil {
+		t.Fatalf("BuildPalette() Error[%v]", err)
+	}
+
+	if p.Background.R != 240 || p.Background.G != 240 || p.Background.B != 240 {
+		t.Errorf("Background error[%v]", p.Background)
+	}
+
+	if !hasColor(p.Foreground, blue) {
+		t.Errorf("Foreground not blue[%v]", p.Foreground)
+	}
+	if !hasColor(p.Foreground, red) {
+		t.Errorf("Foreground not red[%v]", p.Foreground)
+	}
+
+	colors := p.Colors()
+	if len(colors) != 3 {
+		t.Errorf("Colors length error[%d]", len(colors))
+	}
+
+	_, err = BuildPalette(nil, op)
+	if err == nil {
+		t.Errorf("BuildPalette() nil images not error")
+	}
+}
+
+func TestPaletteBuilder(t *testing.T) {
+
+	blue := color.RGBA{R: 20, G: 40, B: 200, A: 255}
+	red := color.RGBA{R: 200, G: 20, B: 20, A: 255}
+	imgs := []image.Image{
+		createNote(60, 60, blue),
+		createNote(50, 40, red),
+	}
+
+	op := DefaultOption()
+	op.SamplingRate = 0.1
+	op.Seed = 3
+
+	expected, err := BuildPalette(imgs, op)
+	if err != nil {
+		t.Fatalf("BuildPalette() Error[%v]", err)
+	}
+
+	b, err := NewPaletteBuilder(op)
+	if err != nil {
+		t.Fatalf("NewPaletteBuilder() Error[%v]", err)
+	}
+	for _, img := range imgs {
+		err = b.Add(img)
+		if err != nil {
+			t.Fatalf("PaletteBuilder.Add() Error[%v]", err)
+		}
+	}
+	p, err := b.Palette()
+	if err != nil {
+		t.Fatalf("PaletteBuilder.Palette() Error[%v]", err)
+	}
+
+	if *p.Background != *expected.Background || len(p.Foreground) != len(expected.Foreground) {
+		t.Fatalf("PaletteBuilder.Palette() not same [%v][%v]", p, expected)
+	}
+	for idx, fg := range p.Foreground {
+		if *fg != *expected.Foreground[idx] {
+			t.Errorf("PaletteBuilder.Palette() foreground not same[%d] [%v]!=[%v]", idx, fg, expected.Foreground[idx])
+		}
+	}
+
+	b, err = NewPaletteBuilder(op)
+	if err != nil {
+		t.Fatalf("NewPaletteBuilder() Error[%v]", err)
+	}
+	_, err = b.Palette()
+	if err == nil {
+		t.Errorf("PaletteBuilder.Palette() no image not error")
+	}
+
+	op.ForegroundNum = 1
+	_, err = NewPaletteBuilder(op)
+	if err == nil {
+		t.Errorf("NewPaletteBuilder() option not error")
+	}
+}
+
+func TestShrinkSet(t *testing.T) {
+
+	blue := color.RGBA{R: 20, G: 40, B: 200, A: 255}
+	red := color.RGBA{R: 200, G: 20, B: 20, A: 255}
+	imgs := []image.Image{
+		createNote(60, 60, blue),
+		createNote(40, 50, red),
+		createNote(60, 60, blue),
+	}
+
+	op := DefaultOption()
+	op.SamplingRate = 0.1
+
+	results, err := ShrinkSet(imgs, op)
+	if err != nil {
+		t.Fatalf("ShrinkSet() Error[%v]", err)
+	}
+	if len(results) != len(imgs) {
+		t.Fatalf("ShrinkSet() length error[%d]", len(results))
+	}
+
+	base := results[0].Image.Palette
+	for i, r := range results {
+
+		//すべて同じパレット
+		if len(r.Image.Palette) != len(base) {
+			t.Fatalf("Palette length error[%d]", i)
+		}
+		for j := range base {
+			if r.Image.Palette[j] != base[j] {
+				t.Errorf("Palette not same[%d][%d]", i, j)
+			}
+		}
+
+		rect := imgs[i].Bounds()
+		if !r.Image.Bounds().Eq(rect) {
+			t.Errorf("Bounds error[%v]!=[%v]", r.Image.Bounds(), rect)
+		}
+	}
+
+	//1ページ目と3ページ目は同じ色
+	c1 := results[0].Image.ColorIndexAt(30, 30)
+	c3 := results[2].Image.ColorIndexAt(30, 30)
+	if c1 != c3 || c1 == 0 {
+		t.Errorf("Foreground index error[%d][%d]", c1, c3)
+	}
+	c2 := results[1].Image.ColorIndexAt(20, 25)
+	if c2 == c1 || c2 == 0 {
+		t.Errorf("Foreground index error[%d][%d]", c1, c2)
+	}
+}
+
+func TestShrinkPalette(t *testing.T) {
+
+	img := createNote(60, 60, color.RGBA{R: 20, G: 40, B: 200, A: 255})
+
+	_, err := ShrinkPalette(img, nil, nil)
+	if err == nil {
+		t.Errorf("ShrinkPalette() nil palette not error")
+	}
+
+	p := &Palette{
+		Background: NewPixelRGB(255, 255, 255),
+		Foreground: Pixels{NewPixelRGB(0, 0, 0), NewPixelRGB(0, 0, 255)},
+	}
+	r, err := ShrinkPalette(img, p, nil)
+	if err != nil {
+		t.Fatalf("ShrinkPalette() Error[%v]", err)
+	}
+
+	if r.Image.ColorIndexAt(0, 0) != 0 {
+		t.Errorf("Background index error[%d]", r.Image.ColorIndexAt(0, 0))
+	}
+	if r.Image.ColorIndexAt(30, 30) != 2 {
+		t.Errorf("Foreground index error[%d]", r.Image.ColorIndexAt(30, 30))
+	}
+}
+
+func TestSaturate(t *testing.T) {
+
+	bg := NewPixelRGB(240, 240, 240)
+
+	tests := []struct {
+		name string
+		fg   Pixels
+		s    []float64
+		v    []float64
+	}{
+		{
+			name: "stretch",
+			fg: Pixels{
+				NewPixelRGB(40, 40, 100),
+				NewPixelRGB(20, 20, 20),
+				NewPixelRGB(200, 100, 100),
+			},
+			s: []float64{1.0, 0.0, 0.5 / 0.6},
+			v: []float64{(100.0 - 20.0) / 220.0, 0.0, (200.0 - 20.0) / 220.0},
+		},
+		{
+			name: "same saturation",
+			fg:   Pixels{NewPixelRGB(60, 30, 120), NewPixelRGB(120, 60, 240)},
+			s:    []float64{0.0, 1.0},
+			v:    []float64{0.0, 1.0},
+		},
+		{
+			name: "empty",
+			fg:   Pixels{},
+		},
+	}
+
+	for _, test := range tests {
+		rtn := saturate(bg, test.fg)
+		if len(rtn) != len(test.fg) {
+			t.Errorf("saturate() %s length error[%d]", test.name, len(rtn))
+			continue
+		}
+		for idx, pix := range rtn {
+			if math.Abs(pix.S-test.s[idx]) > 0.01 || math.Abs(pix.V-test.v[idx]) > 0.01 {
+				t.Errorf("saturate() %s [%d] S[%f]!=[%f] V[%f]!=[%f]",
+					test.name, idx, pix.S, test.s[idx], pix.V, test.v[idx])
+			}
+			if pix.S > 0 && math.Abs(pix.H-test.fg[idx].H) > 0.01 {
+				t.Errorf("saturate() %s [%d] H[%f]!=[%f]", test.name, idx, pix.H, test.fg[idx].H)
+			}
+		}
+	}
+}
+
+func TestAdjust(t *testing.T) {
+
+	p := &Palette{
+		Background: NewPixelRGB(240, 230, 220),
+		Foreground: Pixels{NewPixelRGB(40, 40, 100), NewPixelRGB(20, 20, 20)},
+	}
+
+	op := DefaultOption()
+	rtn := p.adjust(op)
+	if rtn.Background != p.Background || rtn.Foreground[0] != p.Foreground[0] {
+		t.Errorf("adjust() default changed")
+	}
+
+	op.WhiteBackground = true
+	rtn = p.adjust(op)
+	if rtn.Background.R != 255 || rtn.Background.G != 255 || rtn.Background.B != 255 {
+		t.Errorf("adjust() WhiteBackground error[%v]", rtn.Background)
+	}
+	if rtn.Foreground[0] != p.Foreground[0] {
+		t.Errorf("adjust() WhiteBackground foreground changed")
+	}
+
+	op.Saturate = true
+	rtn = p.adjust(op)
+	if rtn.Foreground[1].R != 0 || rtn.Foreground[1].G != 0 || rtn.Foreground[1].B != 0 {
+		t.Errorf("adjust() Saturate error[%v]", rtn.Foreground[1])
+	}
+
+	//元のパレットは変更しない
+	if p.Background.R != 240 || p.Foreground[1].R != 20 {
+		t.Errorf("adjust() palette changed")
+	}
+}
+
+func TestShrinkWhiteBackground(t *testing.T) {
+
+	ink := color.RGBA{R: 20, G: 40, B: 200, A: 255}
+	img := createNote(60, 60, ink)
+
+	op := DefaultOption()
+	op.SamplingRate = 0.1
+	op.WhiteBackground = true
+
+	r, err := ShrinkResult(img, op)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+
+	//判定は元の背景色で行う
+	if r.Background.R != 240 {
+		t.Errorf("Background error[%v]", r.Background)
+	}
+	c := color.RGBAModel.Convert(r.Image.At(0, 0)).(color.RGBA)
+	if c.R != 255 || c.G != 255 || c.B != 255 {
+		t.Errorf("Image background error[%v]", c)
+	}
+	c = color.RGBAModel.Convert(r.Image.At(30, 30)).(color.RGBA)
+	if c != ink {
+		t.Errorf("Image foreground error[%v]", c)
+	}
+}
+
+//Test用のツール
+func hasColor(p Pixels, c color.RGBA) bool {
+	for _, pix := range p {
+		if pix.R == c.R && pix.G == c.G && pix.B == c.B {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,319 @@
+package noteshrink
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+//ページサイズ(pt)
+const (
+	A4Width      = 595.28
+	A4Height     = 841.89
+	LetterWidth  = 612.0
+	LetterHeight = 792.0
+)
+
+//PDFOption はPDF出力時のオプションです
+type PDFOption struct {
+	//DPI は画像の解像度です
+	DPI float64
+	//PageWidth,PageHeight はページの大きさ(pt)です
+	//0の場合は画像の大きさをページの大きさにします
+	PageWidth  float64
+	PageHeight float64
+}
+
+func DefaultPDFOption() *PDFOption {
+	return &PDFOption{
+		DPI: 300,
+	}
+}
+
+//Shrinkの結果を1ページ1画像でPDF出力
+func OutputPDF(f string, results []*Result, op *PDFOption) error {
+
+	return outputFile(f, func(w io.Writer) error {
+		return WritePDF(w, results, op)
+	})
+}
+
+//Shrinkの結果を1ページ1画像でPDFとして書き込みます
+func WritePDF(w io.Writer, results []*Result, op *PDFOption) error {
+
+	if len(results) == 0 {
+		return fmt.Errorf("results length zero")
+	}
+
+	pw, err := NewPDFWriter(w, op)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		err = pw.WritePage(r)
+		if err != nil {
+			return err
+		}
+	}
+	return pw.Close()
+}
+
+//PDFWriter はページごとにPDFを書き込みます
+//
+//ページはすぐに書き込む為、全ページの結果を保持する必要はありません
+//オブジェクト番号は 1:Catalog 2:Pages 以降ページごとに Page,Contents,Image の順になります
+//Pages はページ数が決まる Close() で書き込みます
+type PDFWriter struct {
+	pdf   *objectWriter
+	op    *PDFOption
+	pages int
+}
+
+//ヘッダ、Catalog を書き込みます
+func NewPDFWriter(w io.Writer, op *PDFOption) (*PDFWriter, error) {
+
+	if op == nil {
+		op = DefaultPDFOption()
+	}
+	if op.DPI <= 0 {
+		return nil, fmt.Errorf("DPI must be positive[%f]", op.DPI)
+	}
+
+	pdf := newObjectWriter(w)
+	pdf.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	pdf.begin(1)
+	pdf.printf("<< /Type /Catalog /Pages 2 0 R >>\n")
+	pdf.end()
+
+	if pdf.err != nil {
+		return nil, pdf.err
+	}
+	return &PDFWriter{pdf: pdf, op: op}, nil
+}
+
+//1ページを書き込みます
+func (p *PDFWriter) WritePage(r *Result) error {
+	err := p.pdf.page(3+p.pages*3, r, p.op)
+	if err != nil {
+		return err
+	}
+	p.pages++
+	return nil
+}
+
+//Pages、相互参照表を書き込みます
+//
+//書き込み先は閉じません
+func (p *PDFWriter) Close() error {
+
+	if p.pages == 0 {
+		return fmt.Errorf("pages length zero")
+	}
+
+	pdf := p.pdf
+	pdf.begin(2)
+	pdf.printf("<< /Type /Pages /Kids [")
+	for idx := 0; idx < p.pages; idx++ {
+		pdf.printf(" %d 0 R", 3+idx*3)
+	}
+	pdf.printf(" ] /Count %d >>\n", p.pages)
+	pdf.end()
+
+	return pdf.close()
+}
+
+//PDFの書き込み状態
+type objectWriter struct {
+	w       *bufio.Writer
+	offset  int64
+	objects []int64
+	err     error
+}
+
+func newObjectWriter(w io.Writer) *objectWriter {
+	p := objectWriter{}
+	p.w = bufio.NewWriter(w)
+	p.objects = make([]int64, 0)
+	return &p
+}
+
+//書き込み位置を保持しながら書き込み
+func (p *objectWriter) write(b []byte) {
+	if p.err != nil {
+		return
+	}
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	p.err = err
+}
+
+func (p *objectWriter) printf(format string, args ...interface{}) {
+	p.write([]byte(fmt.Sprintf(format, args...)))
+}
+
+//オブジェクトの開始
+func (p *objectWriter) begin(num int) {
+	for len(p.objects) < num {
+		p.objects = append(p.objects, 0)
+	}
+	p.objects[num-1] = p.offset
+	p.printf("%d 0 obj\n", num)
+}
+
+//オブジェクトの終了
+func (p *objectWriter) end() {
+	p.printf("endobj\n")
+}
+
+//ストリームオブジェクトの書き込み
+func (p *objectWriter) stream(num int, dict string, data []byte) {
+	p.begin(num)
+	p.printf("<< %s/Length %d >>\nstream\n", dict, len(data))
+	p.write(data)
+	p.printf("\nendstream\n")
+	p.end()
+}
+
+//1ページ分(Page,Contents,Image)の書き込み
+func (p *objectWriter) page(num int, r *Result, op *PDFOption) error {
+
+	if r == nil || r.Image == nil {
+		return fmt.Errorf("result is nil")
+	}
+
+	img := r.Image
+	rect := img.Bounds()
+	cols := rect.Dx()
+	rows := rect.Dy()
+	if cols == 0 || rows == 0 {
+		return fmt.Errorf("image size zero")
+	}
+
+	pal := len(img.Palette)
+	if pal == 0 || pal > 256 {
+		return fmt.Errorf("palette length error[%d]", pal)
+	}
+
+	//画像の大きさ(pt)
+	iw := float64(cols) * 72.0 / op.DPI
+	ih := float64(rows) * 72.0 / op.DPI
+
+	pw := op.PageWidth
+	ph := op.PageHeight
+	if pw <= 0 || ph <= 0 {
+		pw = iw
+		ph = ih
+	}
+
+	//ページに収まらない場合は縮小
+	scale := 1.0
+	if iw > pw {
+		scale = pw / iw
+	}
+	if ih*scale > ph {
+		scale = ph / ih
+	}
+	iw *= scale
+	ih *= scale
+	x := (pw - iw) / 2
+	y := (ph - ih) / 2
+
+	p.begin(num)
+	p.printf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] ", pw, ph)
+	p.printf("/Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>\n", num+2, num+1)
+	p.end()
+
+	contents := fmt.Sprintf("q %.4f 0 0 %.4f %.4f %.4f cm /Im0 Do Q", iw, ih, x, y)
+	p.stream(num+1, "", []byte(contents))
+
+	//インデックスカラーのパレット
+	var hex bytes.Buffer
+	for _, c := range img.Palette {
+		r, g, b, _ := c.RGBA()
+		fmt.Fprintf(&hex, "%02X%02X%02X", r>>8, g>>8, b>>8)
+	}
+
+	bpc := bitsPerComponent(pal)
+	data, err := compressIndex(img.Pix, img.Stride, cols, rows, bpc)
+	if err != nil {
+		return err
+	}
+
+	dict := fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d "+
+		"/ColorSpace [/Indexed /DeviceRGB %d <%s>] /BitsPerComponent %d /Filter /FlateDecode ",
+		cols, rows, pal-1, hex.String(), bpc)
+	p.stream(num+2, dict, data)
+
+	return p.err
+}
+
+//相互参照表とトレーラの書き込み
+func (p *objectWriter) close() error {
+
+	xref := p.offset
+	p.printf("xref\n0 %d\n", len(p.objects)+1)
+	p.printf("0000000000 65535 f \n")
+	for _, off := range p.objects {
+		p.printf("%010d 00000 n \n", off)
+	}
+	p.printf("trailer\n<< /Size %d /Root 1 0 R >>\n", len(p.objects)+1)
+	p.printf("startxref\n%d\n%%%%EOF\n", xref)
+
+	if p.err != nil {
+		return p.err
+	}
+	return p.w.Flush()
+}
+
+//パレット数から1画素のビット数を決定
+func bitsPerComponent(n int) int {
+	switch {
+	case n <= 2:
+		return 1
+	case n <= 4:
+		return 2
+	case n <= 16:
+		return 4
+	}
+	return 8
+}
+
+//インデックスを指定ビットに詰めて圧縮
+//
+//各行はバイト境界で揃えます
+func compressIndex(pix []uint8, stride, cols, rows, bpc int) ([]byte, error) {
+
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	line := make([]byte, (cols*bpc+7)/8)
+	for row := 0; row < rows; row++ {
+
+		for i := range line {
+			line[i] = 0
+		}
+
+		src := pix[row*stride : row*stride+cols]
+		for col, v := range src {
+			bit := col * bpc
+			line[bit/8] |= v << uint(8-bpc-bit%8)
+		}
+
+		_, err = zw.Write(line)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
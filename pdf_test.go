@@ -0,0 +1,258 @@
+package noteshrink
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image/color"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestWritePDF(t *testing.T) {
+
+	p := &Palette{
+		Background: NewPixelRGB(240, 240, 240),
+		Foreground: Pixels{
+			NewPixelRGB(20, 40, 200),
+			NewPixelRGB(200, 20, 20),
+			NewPixelRGB(20, 20, 20),
+			NewPixelRGB(20, 200, 20),
+			NewPixelRGB(200, 200, 20),
+		},
+	}
+
+	results := make([]*Result, 0)
+	for _, c := range []color.RGBA{
+		{R: 20, G: 40, B: 200, A: 255},
+		{R: 200, G: 20, B: 20, A: 255},
+		{R: 20, G: 20, B: 20, A: 255},
+	} {
+		r, err := ShrinkPalette(createNote(61, 40, c), p, nil)
+		if err != nil {
+			t.Fatalf("ShrinkPalette() Error[%v]", err)
+		}
+		results = append(results, r)
+	}
+
+	var buf bytes.Buffer
+	err := WritePDF(&buf, results, &PDFOption{DPI: 72})
+	if err != nil {
+		t.Fatalf("WritePDF() Error[%v]", err)
+	}
+	pdf := buf.Bytes()
+
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4")) {
+		t.Errorf("PDF header error")
+	}
+	if !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Errorf("PDF EOF error")
+	}
+
+	pages := bytes.Count(pdf, []byte("/Type /Page "))
+	if pages != len(results) {
+		t.Errorf("PDF page count error[%d]", pages)
+	}
+	if !bytes.Contains(pdf, []byte("/MediaBox [0 0 61.00 40.00]")) {
+		t.Errorf("PDF MediaBox error")
+	}
+
+	//相互参照表のオフセット
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(pdf)
+	if m == nil {
+		t.Fatalf("startxref not found")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(pdf[xref:], []byte("xref\n0 12\n")) {
+		t.Fatalf("xref offset error[%d]", xref)
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n \n`).FindAllSubmatch(pdf[xref:], -1)
+	if len(entries) != 11 {
+		t.Fatalf("xref entries error[%d]", len(entries))
+	}
+	for idx, e := range entries {
+		off, _ := strconv.Atoi(string(e[1]))
+		obj := fmt.Sprintf("%d 0 obj\n", idx+1)
+		if !bytes.HasPrefix(pdf[off:], []byte(obj)) {
+			t.Errorf("xref entry error[%d]", idx+1)
+		}
+	}
+
+	//画像データ(6色なので4bit)
+	if !bytes.Contains(pdf, []byte("/BitsPerComponent 4")) {
+		t.Errorf("BitsPerComponent error")
+	}
+	s := bytes.Index(pdf, []byte("/Subtype /Image"))
+	s += bytes.Index(pdf[s:], []byte("stream\n")) + len("stream\n")
+	e := s + bytes.Index(pdf[s:], []byte("\nendstream"))
+
+	zr, err := zlib.NewReader(bytes.NewReader(pdf[s:e]))
+	if err != nil {
+		t.Fatalf("zlib.NewReader() Error[%v]", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("ReadAll() Error[%v]", err)
+	}
+
+	img := results[0].Image
+	if len(data) != 31*40 {
+		t.Fatalf("image data length error[%d]", len(data))
+	}
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 61; x++ {
+			v := data[y*31+x/2]
+			if x%2 == 0 {
+				v >>= 4
+			}
+			if v&0x0F != img.ColorIndexAt(x, y) {
+				t.Fatalf("image data error[%d,%d]", x, y)
+			}
+		}
+	}
+}
+
+func TestWritePDFPageSize(t *testing.T) {
+
+	r, err := ShrinkResult(createNote(600, 300, color.RGBA{R: 20, G: 40, B: 200, A: 255}), nil)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+
+	var buf bytes.Buffer
+	err = WritePDF(&buf, []*Result{r}, &PDFOption{DPI: 72, PageWidth: 300, PageHeight: 400})
+	if err != nil {
+		t.Fatalf("WritePDF() Error[%v]", err)
+	}
+
+	//横幅に合わせて縮小し、中央に配置
+	pdf := buf.String()
+	if !bytes.Contains(buf.Bytes(), []byte("/MediaBox [0 0 300.00 400.00]")) {
+		t.Errorf("PDF MediaBox error")
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("q 300.0000 0 0 150.0000 0.0000 125.0000 cm /Im0 Do Q")) {
+		t.Errorf("PDF contents error[%s]", pdf)
+	}
+
+	err = WritePDF(&buf, []*Result{r}, &PDFOption{DPI: 0})
+	if err == nil {
+		t.Errorf("WritePDF() DPI zero not error")
+	}
+	err = WritePDF(&buf, nil, nil)
+	if err == nil {
+		t.Errorf("WritePDF() results nil not error")
+	}
+}
+
+func TestPDFWriter(t *testing.T) {
+
+	r, err := ShrinkResult(createNote(60, 40, color.RGBA{R: 20, G: 40, B: 200, A: 255}), nil)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+	op := &PDFOption{DPI: 72}
+
+	var expected bytes.Buffer
+	err = WritePDF(&expected, []*Result{r, r}, op)
+	if err != nil {
+		t.Fatalf("WritePDF() Error[%v]", err)
+	}
+
+	//ページごとに書き込んでも同じ
+	var buf bytes.Buffer
+	pw, err := NewPDFWriter(&buf, op)
+	if err != nil {
+		t.Fatalf("NewPDFWriter() Error[%v]", err)
+	}
+	for i := 0; i < 2; i++ {
+		err = pw.WritePage(r)
+		if err != nil {
+			t.Fatalf("PDFWriter.WritePage() Error[%v]", err)
+		}
+	}
+	err = pw.Close()
+	if err != nil {
+		t.Fatalf("PDFWriter.Close() Error[%v]", err)
+	}
+	if !bytes.Equal(buf.Bytes(), expected.Bytes()) {
+		t.Errorf("PDFWriter not same WritePDF()")
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("/Kids [ 3 0 R 6 0 R ] /Count 2")) {
+		t.Errorf("PDFWriter Pages error")
+	}
+
+	pw, err = NewPDFWriter(&buf, op)
+	if err != nil {
+		t.Fatalf("NewPDFWriter() Error[%v]", err)
+	}
+	err = pw.WritePage(nil)
+	if err == nil {
+		t.Errorf("PDFWriter.WritePage() nil not error")
+	}
+	err = pw.Close()
+	if err == nil {
+		t.Errorf("PDFWriter.Close() no page not error")
+	}
+
+	_, err = NewPDFWriter(&buf, &PDFOption{DPI: -1})
+	if err == nil {
+		t.Errorf("NewPDFWriter() DPI not error")
+	}
+}
+
+func TestOutputPDF(t *testing.T) {
+
+	r, err := ShrinkResult(createNote(60, 60, color.RGBA{R: 20, G: 40, B: 200, A: 255}), nil)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+
+	f := filepath.Join(t.TempDir(), "output.pdf")
+	err = OutputPDF(f, []*Result{r, r}, nil)
+	if err != nil {
+		t.Fatalf("OutputPDF() Error[%v]", err)
+	}
+}
+
+func TestCompressIndex(t *testing.T) {
+
+	pix := []uint8{
+		1, 0, 1, 9,
+		0, 1, 1, 9,
+	}
+
+	for _, bpc := range []int{1, 2, 4, 8} {
+		data, err := compressIndex(pix, 4, 3, 2, bpc)
+		if err != nil {
+			t.Fatalf("compressIndex() Error[%v]", err)
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("zlib.NewReader() Error[%v]", err)
+		}
+		raw, _ := io.ReadAll(zr)
+
+		var expected []byte
+		switch bpc {
+		case 1:
+			expected = []byte{0xA0, 0x60}
+		case 2:
+			expected = []byte{0x44, 0x14}
+		case 4:
+			expected = []byte{0x10, 0x10, 0x01, 0x10}
+		case 8:
+			expected = []byte{1, 0, 1, 0, 1, 1}
+		}
+		if !bytes.Equal(raw, expected) {
+			t.Errorf("compressIndex() bpc[%d] [%x]!=[%x]", bpc, raw, expected)
+		}
+	}
+
+	if bitsPerComponent(2) != 1 || bitsPerComponent(3) != 2 ||
+		bitsPerComponent(6) != 4 || bitsPerComponent(17) != 8 {
+		t.Errorf("bitsPerComponent() error")
+	}
+}
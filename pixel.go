@@ -57,13 +57,26 @@ func NewPixelHSV(h, s, v float64) *Pixel {
 }
 
 //HSVの位置を取得
+//
+//Hは色相環で近い方の距離(0-0.5)になります
 func (p Pixel) DistanceHSV(src *Pixel) (float64, float64, float64) {
-	h := math.Abs(src.H - p.H)
+	h := DistanceHue(src.H, p.H)
 	s := math.Abs(src.S - p.S)
 	v := math.Abs(src.V - p.V)
 	return h, s, v
 }
 
+//色相(0-1)の距離
+//
+//0.99と0.01のように0をまたぐ場合も近い方の距離を返します
+func DistanceHue(a, b float64) float64 {
+	h := math.Mod(math.Abs(a-b), 1.0)
+	if h > 0.5 {
+		h = 1.0 - h
+	}
+	return h
+}
+
 //RGB空間の距離
 func (own Pixel) DistanceRGB(src *Pixel) float64 {
 	all := 0.0
@@ -164,18 +177,18 @@ func (p Pixels) Average() (*Pixel, error) {
 }
 
 //画像の作成
-func (p Pixels) ToImage(cols, rows int) (image.Image,error) {
+func (p Pixels) ToImage(cols, rows int) (image.Image, error) {
 
 	idx := 0
 	img := image.NewRGBA(image.Rect(0, 0, cols, rows))
 
-	for col := 0; col < cols; col++ {
-		for row := 0; row < rows; row++ {
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
 			img.Set(col, row, p[idx].Color())
 			idx++
 		}
 	}
-	return img,nil
+	return img, nil
 }
 
 //ソート
@@ -201,7 +214,7 @@ func (p Pixels) debug(f string) error {
 	if leng > 20 {
 		return fmt.Errorf("NotSupported.")
 	}
-	img,err := p.ToImage(leng, 1)
+	img, err := p.ToImage(leng, 1)
 	if err != nil {
 		return err
 	}
@@ -210,7 +223,7 @@ func (p Pixels) debug(f string) error {
 
 //出力
 func (p Pixels) output(f string, cols, rows int) error {
-	img,err := p.ToImage(cols, rows)
+	img, err := p.ToImage(cols, rows)
 	if err != nil {
 		return err
 	}
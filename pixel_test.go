@@ -89,6 +89,56 @@ func TestDistanceRGB(t *testing.T) {
 }
 
 func TestDistanceHSV(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		p1      *Pixel
+		p2      *Pixel
+		h, s, v float64
+	}{
+		{"same", NewPixelHSV(0.5, 0.5, 0.5), NewPixelHSV(0.5, 0.5, 0.5), 0, 0, 0},
+		{"gray", NewPixelRGB(100, 100, 100), NewPixelRGB(200, 200, 200), 0, 0, 100.0 / 255.0},
+		{"red-blue", NewPixelRGB(255, 0, 0), NewPixelRGB(0, 0, 255), 1.0 / 3.0, 0, 0},
+		{"red-magenta", NewPixelRGB(255, 0, 0), NewPixelRGB(255, 0, 255), 1.0 / 6.0, 0, 0},
+		{"red-cyan", NewPixelRGB(255, 0, 0), NewPixelRGB(0, 255, 255), 0.5, 0, 0},
+		{"saturation", NewPixelRGB(255, 0, 0), NewPixelRGB(255, 128, 128), 0, 0.501961, 0},
+	}
+
+	for _, test := range tests {
+		h, s, v := test.p1.DistanceHSV(test.p2)
+		if !same(h, test.h) || !same(s, test.s) || !same(v, test.v) {
+			t.Errorf("DistanceHSV %s H[%f]S[%f]V[%f]", test.name, h, s, v)
+		}
+		rh, rs, rv := test.p2.DistanceHSV(test.p1)
+		if !same(h, rh) || !same(s, rs) || !same(v, rv) {
+			t.Errorf("DistanceHSV %s reverse H[%f]S[%f]V[%f]", test.name, rh, rs, rv)
+		}
+	}
+}
+
+func TestDistanceHue(t *testing.T) {
+
+	tests := []struct {
+		a, b     float64
+		expected float64
+	}{
+		{0.0, 0.0, 0.0},
+		{0.2, 0.3, 0.1},
+		{0.3, 0.2, 0.1},
+		{0.99, 0.01, 0.02},
+		{0.01, 0.99, 0.02},
+		{0.0, 0.5, 0.5},
+		{0.1, 0.7, 0.4},
+		{0.9, 0.2, 0.3},
+		{0.0, 1.0, 0.0},
+	}
+
+	for _, test := range tests {
+		h := DistanceHue(test.a, test.b)
+		if !same(h, test.expected) {
+			t.Errorf("DistanceHue [%f][%f] [%f]!=[%f]", test.a, test.b, h, test.expected)
+		}
+	}
 }
 
 func TestMost(t *testing.T) {
@@ -1,15 +1,17 @@
 /*
  Shrink() を呼び出すと image.Image をnoteshrinkして image.Imageに変換してくれます。
+ 背景色、前景色などが必要な場合は ShrinkResult() を利用してください。
 
 
 */
 package noteshrink
 
 import (
+	"fmt"
 	"image"
+	"image/color"
 	"math"
 	"math/rand"
-	"time"
 )
 
 //Option はロジックに対し
@@ -20,10 +22,69 @@ type Option struct {
 	ForegroundNum int
 	Shift         int
 	Iterate       int
-}
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
+	//WhiteBackground は出力時の背景色を白にします
+	WhiteBackground bool
+	//Saturate は出力時に前景色の彩度、明度を最大範囲まで広げます
+	Saturate bool
+
+	//Seed はサンプリングなどで利用する乱数のシードです
+	//同じ画像、同じシードであれば同じ結果になります
+	Seed int64
+
+	//Clusterer は前景色の選定方法です
+	//nilの場合は Iterate,ColorSpace を利用した KMeans になります
+	Clusterer Clusterer
+	//ColorSpace は前景色の選定、適用時に距離を測る色空間です
+	ColorSpace ColorSpace
+
+	//Hue は前景色選定時のHの距離(0-0.5)です
+	//0の場合は色相を利用しません。背景、画素とも彩度が低い場合は利用しません
+	Hue float64
+
+	//AutoThreshold はサンプルから Brightness,Saturation を決定します
+	//決定した値は Palette.Brightness,Palette.Saturation になります
+	AutoThreshold bool
+
+	//BackgroundMode は背景色の推定方法です
+	BackgroundMode BackgroundMode
+	//BackgroundColor は FixedBackground で利用する背景色です
+	BackgroundColor *Pixel
+	//BackgroundPercentile は BrightestPercentile で平均する画素の割合(0-1)です
+	//0の場合は0.1になります
+	BackgroundPercentile float64
+
+	//Flatten は照明ムラを補正してから背景色、前景色を選定します
+	Flatten bool
+	//FlattenSize は照明ムラを推定するブロックの大きさ(画素)です
+	//0の場合は短辺の1/16になります
+	FlattenSize int
+
+	//MaskMode は前景色の判定方法です
+	//AdaptiveMask の場合 Hue,AutoThreshold は利用できません
+	MaskMode MaskMode
+	//WindowSize は AdaptiveMask で統計を取る範囲の大きさ(画素)です
+	//0の場合は短辺の1/16になります
+	WindowSize int
+	//SauvolaK は AdaptiveMask の明るさの閾値の係数です
+	//0の場合は0.2になります
+	SauvolaK float64
+
+	//OpenRadius,CloseRadius は前景色の判定後のオープニング、クロージングの半径(画素)です
+	//0の場合は行いません
+	OpenRadius  int
+	CloseRadius int
+	//MinArea は前景色として残す連結領域の最小の画素数です
+	//0の場合は除去しません
+	MinArea int
+
+	//Workers は前景色の判定、適用を行う goroutine の数です
+	//画像を行で分割して処理し、結果は1の場合と同じになります。0の場合は1になります
+	Workers int
+
+	//TileRows は ShrinkTiled() で一度に展開する行数です
+	//0の場合は256になります
+	TileRows int
 }
 
 func DefaultOption() *Option {
@@ -37,270 +98,507 @@ func DefaultOption() *Option {
 	}
 }
 
+//Result はShrinkの結果です
+type Result struct {
+	//Palette は画像から選定した色です
+	//WhiteBackground,Saturate による変換後の色は Image.Palette になります
+	Palette
+
+	//Image はパレットが背景色+前景色の画像です
+	//Image.Pix は Labels と同じスライスの為、一方を変更すると他方も変わります
+	Image *image.Paletted
+
+	//Labels は画素ごとのパレット番号です(0が背景色、i+1がForeground[i])
+	//Image.Pix と共有しています
+	Labels []uint8
+	//Mask は前景色と判定された画素です
+	Mask []bool
+
+	cols int
+	rows int
+}
+
 //圧縮
+//
+//戻り値は *image.Paletted になります
 func Shrink(img image.Image, op *Option) (image.Image, error) {
+	r, err := ShrinkResult(img, op)
+	if err != nil {
+		return nil, err
+	}
+	return r.Image, nil
+}
 
-	if op == nil {
-		op = DefaultOption()
+//圧縮して背景色、前景色、ラベルを含めた結果を返します
+func ShrinkResult(img image.Image, op *Option) (*Result, error) {
+
+	op, err := checkOption(op)
+	if err != nil {
+		return nil, err
 	}
 
 	//データの展開
-	data, err := convertPixels(img)
+	data, err := preparePixels(img, op)
 	if err != nil {
 		return nil, err
 	}
 
 	//サンプルの作成
-	num := int(float64(len(data)) * op.SamplingRate)
-	samples, err := createSample(data, num)
+	rnd := newRand(op)
+	num := int(float64(data.len()) * op.SamplingRate)
+	index, err := createSampleIndex(data.len(), num, rnd)
+	if err != nil {
+		return nil, err
+	}
+	samples := data.subset(index)
+
+	//背景色を取得
+	bg, err := getBackgroundColor(backgroundPixels(data, samples, op), op)
 	if err != nil {
 		return nil, err
 	}
 
 	//色の選定
-	bg, palette, err := createPalette(samples, op)
+	mask := imageMask(data, op)
+	p, err := createPalette(samples, sampleMask(mask, index), bg, op, rnd)
 	if err != nil {
 		return nil, err
 	}
+	return applyPalette(data, mask, p, op)
+}
 
-	//色の適用
-	shrink, err := apply(data, bg, palette, op)
+//作成済のパレットで圧縮します
+//
+//BuildPalette() と組み合わせて複数の画像で同じ色を利用する場合に使用します
+func ShrinkPalette(img image.Image, p *Palette, op *Option) (*Result, error) {
+
+	op, err := checkOption(op)
 	if err != nil {
 		return nil, err
 	}
 
-	//GIF用にパレットを作成
-	setGIFPalette(bg, palette)
+	if p == nil || p.Background == nil {
+		return nil, fmt.Errorf("palette is nil")
+	}
+	if len(p.Foreground)+1 > 256 {
+		return nil, fmt.Errorf("palette length error[%d]", len(p.Foreground)+1)
+	}
 
-	rect := img.Bounds()
-	cols := rect.Dx()
-	rows := rect.Dy()
+	//データの展開
+	data, err := preparePixels(img, op)
+	if err != nil {
+		return nil, err
+	}
 
-	return shrink.ToImage(cols, rows)
+	return applyPalette(data, nil, p, op)
 }
 
-//色を適用
-func apply(data Pixels, bg *Pixel, labels Pixels, op *Option) (Pixels, error) {
+//オプションの確認
+func checkOption(op *Option) (*Option, error) {
 
-	//使用箇所を取得
-	flag, err := getForegraundMask(data, bg, op)
+	if op == nil {
+		op = DefaultOption()
+	}
+
+	if op.ForegroundNum < 2 || op.ForegroundNum > 256 {
+		return nil, fmt.Errorf("ForegroundNum must be 2-256[%d]", op.ForegroundNum)
+	}
+
+	switch op.MaskMode {
+	case GlobalMask:
+	case AdaptiveMask:
+		if op.AutoThreshold {
+			return nil, fmt.Errorf("AutoThreshold not supported with AdaptiveMask")
+		}
+		if op.Hue > 0 {
+			return nil, fmt.Errorf("Hue not supported with AdaptiveMask")
+		}
+	default:
+		return nil, fmt.Errorf("MaskMode error[%v]", op.MaskMode)
+	}
+
+	if op.OpenRadius < 0 || op.CloseRadius < 0 || op.MinArea < 0 {
+		return nil, fmt.Errorf("OpenRadius,CloseRadius,MinArea must not be negative")
+	}
+	if op.Workers < 0 {
+		return nil, fmt.Errorf("Workers must not be negative[%d]", op.Workers)
+	}
+	return op, nil
+}
+
+//画像を展開し、オプションに従い前処理を行います
+func preparePixels(img image.Image, op *Option) (*pixelBuffer, error) {
+
+	data, err := convertPixels(img)
+	if err != nil {
+		return nil, err
+	}
+
+	if op.Flatten {
+		data = flatten(data, op.FlattenSize)
+	}
+	return data, nil
+}
+
+//呼び出しごとの乱数を作成
+func newRand(op *Option) *rand.Rand {
+	return rand.New(rand.NewSource(op.Seed))
+}
+
+//パレットを適用して結果を作成
+//
+//mask は画像全体の前景色の判定です。nilの場合はパレットの背景色から判定します
+func applyPalette(data *pixelBuffer, mask []bool, p *Palette, op *Option) (*Result, error) {
+
+	//色の適用
+	labels, mask, err := apply(data, mask, p.Background, p.Foreground, p.threshold(op))
+	if err != nil {
+		return nil, err
+	}
+
+	r := Result{
+		Palette: *p,
+		Labels:  labels,
+		Mask:    mask,
+		cols:    data.cols,
+		rows:    data.rows,
+	}
+
+	//出力用の色に変換
+	out := p.adjust(op)
+
+	r.Image, err = r.toImage(out.Colors())
 	if err != nil {
 		return nil, err
 	}
+	return &r, nil
+}
+
+//ラベルから画像を作成
+//
+//画素データはLabelsをそのまま利用します
+func (r *Result) toImage(pal color.Palette) (*image.Paletted, error) {
+
+	if len(r.Labels) != r.cols*r.rows {
+		return nil, fmt.Errorf("labels length error[%d]!=[%d]", len(r.Labels), r.cols*r.rows)
+	}
+
+	img := &image.Paletted{
+		Pix:     r.Labels,
+		Stride:  r.cols,
+		Rect:    image.Rect(0, 0, r.cols, r.rows),
+		Palette: pal,
+	}
+	return img, nil
+}
+
+//色を適用
+//
+//flag は前景色の判定です。nilの場合は背景色から判定します
+func apply(data *pixelBuffer, flag []bool, bg *Pixel, labels Pixels, op *Option) ([]uint8, []bool, error) {
 
-	rtn := make([]*Pixel, len(data))
-	for idx := 0; idx < len(data); idx++ {
-		newPix := bg
-		if flag[idx] {
-			//近いラベルを取得
-			wk := closest(data[idx], labels)
-			newPix = labels[wk]
+	//使用箇所を取得
+	if flag == nil {
+		var err error
+		flag, err = getForegraundMask(data, bg, op)
+		if err != nil {
+			return nil, nil, err
 		}
-		rtn[idx] = newPix
+	} else if len(flag) != data.len() {
+		return nil, nil, fmt.Errorf("mask length error[%d]!=[%d]", len(flag), data.len())
 	}
-	return rtn, nil
+	flag = cleanMask(flag, data.cols, data.rows, op)
+
+	return applyMatcher(data, flag, newMatcher(labels, op.ColorSpace), op), flag, nil
+}
+
+//前景色の画素にラベルを割り当て
+func applyMatcher(data *pixelBuffer, flag []bool, m *matcher, op *Option) []uint8 {
+
+	rtn := make([]uint8, data.len())
+	rowBands(data.rows, op.Workers, func(y0, y1 int) {
+		for idx := y0 * data.cols; idx < y1*data.cols; idx++ {
+			if flag[idx] {
+				//近いラベルを取得
+				rtn[idx] = uint8(m.lookup(data.pix[idx]) + 1)
+			}
+		}
+	})
+	return rtn
 }
 
 //使用する色を検索
-func createPalette(p Pixels, op *Option) (*Pixel, Pixels, error) {
+//
+//mask はサンプルごとの前景色の判定です。nilの場合はサンプルと背景色から判定します
+func createPalette(p *pixelBuffer, mask []bool, bg *Pixel, op *Option, rnd *rand.Rand) (*Palette, error) {
 
-	//背景色を取得
-	bg, err := getBackgroundColor(p, op)
-	if err != nil {
-		return nil, nil, err
+	//閾値を決定
+	if op.AutoThreshold {
+		op = autoThreshold(p, bg, op)
 	}
 
 	//使用箇所を特定
-	mask, err := getForegraundMask(p, bg, op)
-	if err != nil {
-		return bg, nil, err
+	if mask == nil {
+		var err error
+		mask, err = getForegraundMask(p, bg, op)
+		if err != nil {
+			return nil, err
+		}
+	} else if len(mask) != p.len() {
+		return nil, fmt.Errorf("mask length error[%d]!=[%d]", len(mask), p.len())
 	}
 
 	//適用だけを残す
-	target := make([]*Pixel, 0, len(p))
-	for i, pix := range p {
+	index := make([]int, 0, p.len())
+	for i := range p.pix {
 		if mask[i] {
-			target = append(target, pix)
+			index = append(index, i)
 		}
 	}
 
 	//色を決定
-	labels, err := kmeans(target, op)
+	k := op.ForegroundNum - 1
+	labels, err := op.clusterer().Cluster(p.subset(index).pixels(), k, rnd)
 	if err != nil {
-		return bg, nil, err
+		return nil, err
 	}
 
-	return bg, labels, nil
+	if len(labels) > k {
+		return nil, fmt.Errorf("cluster length error[%d]>[%d]", len(labels), k)
+	}
+	for _, label := range labels {
+		if label == nil {
+			return nil, fmt.Errorf("cluster label is nil")
+		}
+	}
+
+	return newPalette(bg, labels, op), nil
 }
 
-//背景色を取得
-func getBackgroundColor(p Pixels, op *Option) (*Pixel, error) {
+//自動判定時の閾値の範囲
+//
+//前景色が少ない場合にノイズを前景色としない為、下限を設けます
+const (
+	autoThresholdMin = 0.1
+	autoThresholdMax = 0.6
+)
 
-	//色を落とす
-	q, err := p.Quantize(op.Shift)
-	if err != nil {
-		return nil, err
+//サンプルの背景色との距離から Brightness,Saturation を決定
+func autoThreshold(p *pixelBuffer, bg *Pixel, op *Option) *Option {
+
+	dv := make([]float64, p.len())
+	ds := make([]float64, p.len())
+	for idx := range p.pix {
+		_, s, v := p.hsv(idx)
+		dv[idx] = math.Abs(v - bg.V)
+		ds[idx] = math.Abs(s - bg.S)
 	}
-	//一番多い色を取得
-	col := q.Most()
 
-	return col, nil
+	rtn := *op
+	rtn.Brightness = clamp(otsu(dv), autoThresholdMin, autoThresholdMax)
+	rtn.Saturation = clamp(otsu(ds), autoThresholdMin, autoThresholdMax)
+	return &rtn
 }
 
-//サンプルを抽出
-func createSample(p Pixels, num int) (Pixels, error) {
+//大津の手法で0-1の値を2つに分ける閾値を取得
+//
+//閾値以上の値が上のクラスになります
+func otsu(values []float64) float64 {
 
-	samples := make([]*Pixel, num)
-	leng := len(p)
-	for idx := 0; idx < num; idx++ {
-		samples[idx] = p[rand.Intn(leng)]
+	const bins = 256
+	hist := make([]int, bins)
+	for _, v := range values {
+		b := int(clamp(v, 0, 1) * (bins - 1))
+		hist[b]++
 	}
-	return samples, nil
-}
 
-//HSV空間からの距離により、使用箇所を特定
-func getForegraundMask(p Pixels, bg *Pixel, op *Option) ([]bool, error) {
+	total := len(values)
+	sum := 0.0
+	for i, n := range hist {
+		sum += float64(i * n)
+	}
 
-	rtn := make([]bool, len(p))
-	for idx, pix := range p {
-		_, ds, dv := pix.DistanceHSV(bg)
-		rtn[idx] = dv >= op.Brightness || ds >= op.Saturation
+	best := 0.0
+	rtn := 0
+	w0 := 0
+	sum0 := 0.0
+	for i := 0; i < bins-1; i++ {
+		w0 += hist[i]
+		sum0 += float64(i * hist[i])
+		w1 := total - w0
+		if w0 == 0 || w1 == 0 {
+			continue
+		}
+		m0 := sum0 / float64(w0)
+		m1 := (sum - sum0) / float64(w1)
+		between := float64(w0) * float64(w1) * (m0 - m1) * (m0 - m1)
+		if between > best {
+			best = between
+			rtn = i + 1
+		}
 	}
-	return rtn, nil
+	return float64(rtn) / (bins - 1)
 }
 
-//kmeansで色を特定
-func kmeans(p Pixels, op *Option) ([]*Pixel, error) {
-
-	k := op.ForegroundNum - 1
-	itr := op.Iterate
-
-	labels := make([]*Pixel, k)
-	for i := 0; i < k; i++ {
-		h := float64(i) / float64(k-1)
-		pixel := NewPixelHSV(h, 1, 1)
-		labels[i] = pixel
-	}
+//背景色を取得
+//
+//p は backgroundPixels() で取得した画素です
+func getBackgroundColor(p *pixelBuffer, op *Option) (*Pixel, error) {
 
-	index := make([]int, len(p))
-	for idx, pix := range p {
-		index[idx] = closest(pix, labels)
+	if op.Shift < 0 || op.Shift >= 8 {
+		return nil, fmt.Errorf("shift not 8 over")
 	}
 
-	for idx := 0; idx < itr; idx++ {
-
-		groups := make([]Pixels, len(labels))
-		for i := range labels {
-			groups[i] = make([]*Pixel, 0, len(labels))
+	switch op.BackgroundMode {
+	case MostFrequent, BorderSampling:
+		return mostFrequentColor(p, op)
+	case BrightestPercentile:
+		return brightestColor(p, op.BackgroundPercentile)
+	case FixedBackground:
+		if op.BackgroundColor == nil {
+			return nil, fmt.Errorf("BackgroundColor is nil")
 		}
+		return NewPixelRGB(op.BackgroundColor.R, op.BackgroundColor.G, op.BackgroundColor.B), nil
+	}
+	return nil, fmt.Errorf("BackgroundMode error[%v]", op.BackgroundMode)
+}
 
-		for i, pix := range p {
-			label := index[i]
-			groups[label] = append(groups[label], pix)
-		}
+//最も多い色を取得
+func mostFrequentColor(p *pixelBuffer, op *Option) (*Pixel, error) {
 
-		for i := range labels {
-			if newLabel, err := groups[i].Average(); newLabel != nil && err == nil {
-				labels[i] = newLabel
-			} else if err != nil {
-				//TODO error
-				//return nil,err
-			}
-		}
+	//色を落とす
+	shift := uint(op.Shift)
+	mask := uint32(0xFF>>shift<<shift) * 0x010101
 
-		changes := 0
-		for i, pix := range p {
-			if newIdx := closest(pix, labels); newIdx != index[i] {
-				changes++
-				index[i] = newIdx
-			}
-		}
+	//一番多い色を取得
+	counter := make(map[uint32]int)
+	for _, v := range p.pix {
+		counter[v&mask]++
+	}
 
-		if changes == 0 {
-			break
+	max := 0
+	value := uint32(0)
+	for key, elm := range counter {
+		if elm > max || (elm == max && key < value) {
+			max = elm
+			value = key
 		}
 	}
+	return NewPixelRGB(unpackRGB(value)), nil
+}
 
-	return labels, nil
+//サンプルを抽出
+func createSample(p *pixelBuffer, num int, rnd *rand.Rand) (*pixelBuffer, error) {
+	index, err := createSampleIndex(p.len(), num, rnd)
+	if err != nil {
+		return nil, err
+	}
+	return p.subset(index), nil
 }
 
-//近い位置を取得
-func closest(p *Pixel, labels []*Pixel) int {
-	idx := -1
-	d := math.MaxFloat64
-	for i := 0; i < len(labels); i++ {
-		val := p.DistanceRGB(labels[i])
-		if val < d {
-			d = val
-			idx = i
-		}
+//サンプルの位置を作成
+func createSampleIndex(leng, num int, rnd *rand.Rand) ([]int, error) {
+
+	if leng == 0 {
+		return nil, fmt.Errorf("pixels length zero")
 	}
-	return idx
+
+	index := make([]int, num)
+	for idx := 0; idx < num; idx++ {
+		index[idx] = rnd.Intn(leng)
+	}
+	return index, nil
 }
 
-//一般化してみたやつ（未使用）
-type Value interface {
-	Distance(Value) float64
-	Average([]Value) (Value, error)
+//パレットの作成前に判定できる画像全体の前景色の判定
+//
+//AdaptiveMask の場合は周囲の統計で判定した結果を返します
+//それ以外は背景色、閾値から判定する為、nilを返します
+func imageMask(data *pixelBuffer, op *Option) []bool {
+	if op.MaskMode != AdaptiveMask {
+		return nil
+	}
+	return adaptiveMask(data, op)
 }
 
-func kmeansValue(data []Value, labels []Value, itr int) []Value {
+//サンプルの前景色の判定
+//
+//mask は imageMask() の結果で、nilの場合はnilを返します
+func sampleMask(mask []bool, index []int) []bool {
 
-	index := make([]int, len(data))
-	for idx, datum := range data {
-		index[idx] = closestIndex(datum, labels)
+	if mask == nil {
+		return nil
 	}
 
-	rtn := make([]Value, len(labels))
-	for idx, label := range labels {
-		rtn[idx] = label
+	rtn := make([]bool, len(index))
+	for i, idx := range index {
+		rtn[i] = mask[idx]
 	}
+	return rtn
+}
 
-	for idx := 0; idx < itr; idx++ {
+//HSV空間からの距離により、使用箇所を特定
+//
+//AdaptiveMask の場合は p を画像として周囲の統計で判定します
+func getForegraundMask(p *pixelBuffer, bg *Pixel, op *Option) ([]bool, error) {
 
-		groups := make([][]Value, len(rtn))
-		for i := range rtn {
-			groups[i] = make([]Value, 0, len(data))
-		}
+	if op.MaskMode == AdaptiveMask {
+		return adaptiveMask(p, op), nil
+	}
 
-		for i, elm := range data {
-			idx := index[i]
-			groups[idx] = append(groups[idx], elm)
+	rtn := make([]bool, p.len())
+	rowBands(p.rows, op.Workers, func(y0, y1 int) {
+		for idx := y0 * p.cols; idx < y1*p.cols; idx++ {
+			h, s, v := p.hsv(idx)
+			rtn[idx] = isForeground(h, s, v, bg, op)
 		}
+	})
+	return rtn, nil
+}
 
-		for i, label := range rtn {
-			valSlice := groups[i]
-			ave, err := label.Average(valSlice)
-			if ave != nil && err == nil {
-				rtn[i] = ave
-			} else if err != nil {
-			}
-		}
+//色相を比較する際の最低の彩度
+//
+//彩度が低い色の色相はノイズで大きく変わる為、比較しません
+const hueSaturation = 0.1
 
-		changes := 0
-		for i, pix := range data {
-			if newIdx := closestIndex(pix, rtn); newIdx != index[i] {
-				changes++
-				index[i] = newIdx
-			}
-		}
+//背景色との距離で前景色かを判定
+func isForeground(h, s, v float64, bg *Pixel, op *Option) bool {
 
-		if changes == 0 {
-			break
-		}
+	ds := math.Abs(s - bg.S)
+	dv := math.Abs(v - bg.V)
+	if dv >= op.Brightness || ds >= op.Saturation {
+		return true
 	}
-	return rtn
+
+	if op.Hue > 0 && s >= hueSaturation && bg.S >= hueSaturation {
+		return DistanceHue(h, bg.H) >= op.Hue
+	}
+	return false
 }
 
-func closestIndex(val Value, labels []Value) int {
-	rtn := -1
-	min := math.MaxFloat64
-	for idx, elm := range labels {
-		vd := val.Distance(elm)
-		if vd < min {
-			min = vd
-			rtn = idx
+//近い位置を取得
+func closest(p *Pixel, labels []*Pixel) int {
+	return closestRGB(p.R, p.G, p.B, labels)
+}
+
+//RGB空間で近い位置を取得
+func closestRGB(r, g, b uint8, labels []*Pixel) int {
+	idx := -1
+	d := math.MaxInt
+	for i, label := range labels {
+		val := distanceRGB(r, g, b, label)
+		if val < d {
+			d = val
+			idx = i
 		}
 	}
-	return rtn
+	return idx
+}
+
+//RGB空間の距離(2乗)
+func distanceRGB(r, g, b uint8, label *Pixel) int {
+	dr := int(r) - int(label.R)
+	dg := int(g) - int(label.G)
+	db := int(b) - int(label.B)
+	return dr*dr + dg*dg + db*db
 }
@@ -1,10 +1,18 @@
 package noteshrink
 
 import (
+	"bytes"
+	"fmt"
 	"image"
+	"image/color"
+	_ "image/gif"
 	_ "image/jpeg"
-	_ "image/png"
+	"image/png"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
 	"testing"
 )
 
@@ -35,12 +43,12 @@ func TestBackground(t *testing.T) {
 
 	op := DefaultOption()
 
-	samples, err := createSample(pix, 10000)
+	samples, err := createSample(pix, 10000, newRand(op))
 	if err != nil {
 		t.Errorf("CreateSample[%v]", err)
 	}
 
-	samples.output("sample/notesA1_samples.png", 100, 100)
+	samples.pixels().output("sample/notesA1_samples.png", 100, 100)
 
 	op.Shift = 4
 	bg, err := getBackgroundColor(samples, op)
@@ -64,12 +72,12 @@ func TestQuantaizeSample(t *testing.T) {
 		return
 	}
 
-	samples, err := createSample(pix, 10000)
+	samples, err := createSample(pix, 10000, newRand(DefaultOption()))
 	if err != nil {
 		t.Errorf("CreateSample[%v]", err)
 	}
 
-	q, err := samples.Quantize(2)
+	q, err := samples.pixels().Quantize(2)
 	if err != nil {
 		t.Errorf("Test Quantize:Pack [%v]", err)
 	}
@@ -82,6 +90,431 @@ func TestQuantaizeSample(t *testing.T) {
 	}
 }
 
+func TestShrinkResult(t *testing.T) {
+
+	ink := color.RGBA{R: 20, G: 40, B: 200, A: 255}
+	img := createNote(100, 80, ink)
+
+	op := DefaultOption()
+	op.SamplingRate = 0.1
+
+	r, err := ShrinkResult(img, op)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+
+	if len(r.Labels) != 100*80 || len(r.Mask) != 100*80 {
+		t.Fatalf("Result length error Labels[%d] Mask[%d]", len(r.Labels), len(r.Mask))
+	}
+	//1色しかない場合は警告
+	if len(r.Foreground) != 1 {
+		t.Errorf("Foreground length error[%d]", len(r.Foreground))
+	}
+	if len(r.Warnings) == 0 {
+		t.Errorf("Warnings is empty")
+	}
+	if r.Background.R != 240 || r.Background.G != 240 || r.Background.B != 240 {
+		t.Errorf("Background error[%v]", r.Background)
+	}
+
+	//背景
+	if r.Mask[0] || r.Labels[0] != 0 {
+		t.Errorf("Background pixel error Mask[%v] Label[%d]", r.Mask[0], r.Labels[0])
+	}
+
+	//前景(行優先)
+	idx := 30*100 + 50
+	if !r.Mask[idx] || r.Labels[idx] == 0 {
+		t.Fatalf("Foreground pixel error Mask[%v] Label[%d]", r.Mask[idx], r.Labels[idx])
+	}
+	fg := r.Foreground[r.Labels[idx]-1]
+	if fg.R != ink.R || fg.G != ink.G || fg.B != ink.B {
+		t.Errorf("Foreground color error[%v]", fg)
+	}
+
+	c := color.RGBAModel.Convert(r.Image.At(50, 30)).(color.RGBA)
+	if c != ink {
+		t.Errorf("Image color error[%v]", c)
+	}
+
+	//パレット
+	if len(r.Image.Palette) != len(r.Foreground)+1 {
+		t.Errorf("Palette length error[%d]", len(r.Image.Palette))
+	}
+	if r.Image.ColorIndexAt(50, 30) != r.Labels[idx] {
+		t.Errorf("ColorIndexAt error[%d]!=[%d]", r.Image.ColorIndexAt(50, 30), r.Labels[idx])
+	}
+	if len(r.Image.Pix) != 100*80 {
+		t.Errorf("Pix length error[%d]", len(r.Image.Pix))
+	}
+}
+
+func TestShrinkPaletted(t *testing.T) {
+
+	ink := color.RGBA{R: 20, G: 40, B: 200, A: 255}
+	img := createNote(100, 80, ink)
+
+	op := DefaultOption()
+	op.SamplingRate = 0.1
+
+	shrink, err := Shrink(img, op)
+	if err != nil {
+		t.Fatalf("Shrink() Error[%v]", err)
+	}
+	if _, ok := shrink.(*image.Paletted); !ok {
+		t.Fatalf("Shrink() not Paletted[%T]", shrink)
+	}
+
+	//PNGはインデックスカラーで出力
+	f := filepath.Join(t.TempDir(), "paletted.png")
+	err = OutputPNG(f, shrink)
+	if err != nil {
+		t.Fatalf("OutputPNG() Error[%v]", err)
+	}
+	pngImg, err := loadImage(f)
+	if err != nil {
+		t.Fatalf("loadImage() Error[%v]", err)
+	}
+	p, ok := pngImg.(*image.Paletted)
+	if !ok {
+		t.Fatalf("PNG not Paletted[%T]", pngImg)
+	}
+	if len(p.Palette) != len(shrink.(*image.Paletted).Palette) {
+		t.Errorf("PNG palette length error[%d]", len(p.Palette))
+	}
+
+	//Labels と Image.Pix は共有
+	r, err := ShrinkResult(img, op)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+	r.Labels[0] = 1
+	if r.Image.ColorIndexAt(0, 0) != 1 {
+		t.Errorf("Labels not shared with Image.Pix")
+	}
+}
+
+//go test -race で確認
+func TestShrinkResultRace(t *testing.T) {
+
+	dir := t.TempDir()
+	op := DefaultOption()
+	op.SamplingRate = 0.1
+
+	num := 16
+	wg := sync.WaitGroup{}
+	for i := 0; i < num; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			ink := color.RGBA{R: uint8(i * 15), G: 0, B: uint8(255 - i*15), A: 255}
+			img := createNote(60, 60, ink)
+
+			r, err := ShrinkResult(img, op)
+			if err != nil {
+				t.Errorf("ShrinkResult() Error[%v]", err)
+				return
+			}
+
+			f := filepath.Join(dir, fmt.Sprintf("race%d.gif", i))
+			err = OutputResultGIF(f, r)
+			if err != nil {
+				t.Errorf("OutputResultGIF() Error[%v]", err)
+				return
+			}
+
+			gifImg, err := loadImage(f)
+			if err != nil {
+				t.Errorf("loadImage() Error[%v]", err)
+				return
+			}
+
+			c := color.RGBAModel.Convert(gifImg.At(30, 30)).(color.RGBA)
+			if c != ink {
+				t.Errorf("GIF color error[%d] [%v]!=[%v]", i, c, ink)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestOutputGIF(t *testing.T) {
+
+	ink := color.RGBA{R: 200, G: 20, B: 20, A: 255}
+	img := createNote(60, 60, ink)
+
+	op := DefaultOption()
+	op.SamplingRate = 0.1
+	shrink, err := Shrink(img, op)
+	if err != nil {
+		t.Fatalf("Shrink() Error[%v]", err)
+	}
+
+	f := filepath.Join(t.TempDir(), "output.gif")
+	err = OutputGIF(f, shrink)
+	if err != nil {
+		t.Fatalf("OutputGIF() Error[%v]", err)
+	}
+
+	gifImg, err := loadImage(f)
+	if err != nil {
+		t.Fatalf("loadImage() Error[%v]", err)
+	}
+	c := color.RGBAModel.Convert(gifImg.At(30, 30)).(color.RGBA)
+	if c != ink {
+		t.Errorf("GIF color error[%v]", c)
+	}
+
+	//256色以上はエラー
+	err = OutputGIF(f, createGradation(32, 32))
+	if err == nil {
+		t.Errorf("OutputGIF() 256 over colors not error")
+	}
+}
+
+func TestShrinkSeed(t *testing.T) {
+
+	//ノイズの多い画像
+	rnd := rand.New(rand.NewSource(10))
+	img := createNote(120, 90, color.RGBA{R: 20, G: 40, B: 200, A: 255})
+	for i := range img.Pix {
+		if i%4 != 3 {
+			img.Pix[i] = uint8(int(img.Pix[i]) * (200 + rnd.Intn(56)) / 255)
+		}
+	}
+	for i := 0; i < 300; i++ {
+		img.SetRGBA(rnd.Intn(120), rnd.Intn(90), color.RGBA{R: uint8(rnd.Intn(256)), G: 20, B: 20, A: 255})
+	}
+
+	encode := func(seed int64) []byte {
+		op := DefaultOption()
+		op.SamplingRate = 0.05
+		op.Seed = seed
+		shrink, err := Shrink(img, op)
+		if err != nil {
+			t.Fatalf("Shrink() Error[%v]", err)
+		}
+		var buf bytes.Buffer
+		err = png.Encode(&buf, shrink)
+		if err != nil {
+			t.Fatalf("png.Encode() Error[%v]", err)
+		}
+		return buf.Bytes()
+	}
+
+	for _, seed := range []int64{0, 1, 12345} {
+		first := encode(seed)
+		for i := 0; i < 3; i++ {
+			if !bytes.Equal(first, encode(seed)) {
+				t.Errorf("Seed[%d] output not same", seed)
+			}
+		}
+	}
+
+	//サンプリングも同じ
+	data, err := convertPixels(img)
+	if err != nil {
+		t.Fatalf("convertPixels() Error[%v]", err)
+	}
+	op := DefaultOption()
+	op.Seed = 5
+	s1, _ := createSample(data, 100, newRand(op))
+	s2, _ := createSample(data, 100, newRand(op))
+	op.Seed = 6
+	s3, _ := createSample(data, 100, newRand(op))
+	same := true
+	for i := range s1.pix {
+		if s1.pix[i] != s2.pix[i] {
+			t.Fatalf("createSample() not same[%d]", i)
+		}
+		if s1.pix[i] != s3.pix[i] {
+			same = false
+		}
+	}
+	if same {
+		t.Errorf("createSample() other seed same")
+	}
+}
+
+func TestForegroundMaskHue(t *testing.T) {
+
+	//黄色の紙にピンクの蛍光ペン(明度、彩度は同程度)
+	paper := NewPixelRGB(250, 235, 170)
+	data := newPixelBuffer(4, 1)
+	data.pix[0] = packRGB(250, 235, 170)
+	data.pix[1] = packRGB(250, 170, 235)
+	data.pix[2] = packRGB(248, 236, 172)
+	data.pix[3] = packRGB(40, 40, 40)
+
+	op := DefaultOption()
+	mask, err := getForegraundMask(data, paper, op)
+	if err != nil {
+		t.Fatalf("getForegraundMask() Error[%v]", err)
+	}
+	expected := []bool{false, false, false, true}
+	for idx, m := range mask {
+		if m != expected[idx] {
+			t.Errorf("getForegraundMask() Hue zero [%d] %v", idx, m)
+		}
+	}
+
+	op.Hue = 0.1
+	mask, err = getForegraundMask(data, paper, op)
+	if err != nil {
+		t.Fatalf("getForegraundMask() Error[%v]", err)
+	}
+	expected = []bool{false, true, false, true}
+	for idx, m := range mask {
+		if m != expected[idx] {
+			t.Errorf("getForegraundMask() Hue [%d] %v", idx, m)
+		}
+	}
+
+	//白い紙では色相のノイズを無視する
+	white := NewPixelRGB(240, 240, 240)
+	data.pix[0] = packRGB(240, 240, 240)
+	data.pix[1] = packRGB(240, 236, 240)
+	data.pix[2] = packRGB(236, 240, 238)
+	mask, err = getForegraundMask(data, white, op)
+	if err != nil {
+		t.Fatalf("getForegraundMask() Error[%v]", err)
+	}
+	for idx, m := range mask[:3] {
+		if m {
+			t.Errorf("getForegraundMask() white paper [%d] is foreground", idx)
+		}
+	}
+}
+
+func TestWorkers(t *testing.T) {
+
+	//行数が goroutine の数で割り切れない画像
+	rnd := rand.New(rand.NewSource(3))
+	img := createVignette(97, 61)
+	for i := 0; i < 200; i++ {
+		img.SetRGBA(rnd.Intn(97), rnd.Intn(61), color.RGBA{R: uint8(rnd.Intn(256)), G: uint8(rnd.Intn(256)), B: 90, A: 255})
+	}
+
+	options := map[string]func(op *Option){
+		"global":   func(op *Option) {},
+		"hue":      func(op *Option) { op.Hue = 0.1 },
+		"adaptive": func(op *Option) { op.MaskMode = AdaptiveMask; op.WindowSize = 21 },
+		"lab":      func(op *Option) { op.ColorSpace = CIELAB },
+	}
+
+	for name, set := range options {
+
+		op := DefaultOption()
+		op.SamplingRate = 0.1
+		set(op)
+		serial, err := ShrinkResult(img, op)
+		if err != nil {
+			t.Fatalf("ShrinkResult() %s Error[%v]", name, err)
+		}
+
+		for _, w := range []int{2, 3, 7, 100} {
+			op.Workers = w
+			r, err := ShrinkResult(img, op)
+			if err != nil {
+				t.Fatalf("ShrinkResult() %s Workers[%d] Error[%v]", name, w, err)
+			}
+			if !bytes.Equal(serial.Labels, r.Labels) {
+				t.Errorf("ShrinkResult() %s Workers[%d] labels not same", name, w)
+			}
+			for idx := range r.Mask {
+				if serial.Mask[idx] != r.Mask[idx] {
+					t.Errorf("ShrinkResult() %s Workers[%d] mask not same[%d]", name, w, idx)
+					break
+				}
+			}
+		}
+	}
+
+	op := DefaultOption()
+	op.Workers = -1
+	_, err := ShrinkResult(img, op)
+	if err == nil {
+		t.Errorf("Workers negative not error")
+	}
+}
+
+func TestOtsu(t *testing.T) {
+
+	values := make([]float64, 0, 100)
+	for i := 0; i < 80; i++ {
+		values = append(values, 0.02+float64(i%5)*0.01)
+	}
+	for i := 0; i < 20; i++ {
+		values = append(values, 0.5+float64(i%5)*0.01)
+	}
+
+	th := otsu(values)
+	if th <= 0.06 || th > 0.5 {
+		t.Errorf("otsu() threshold [%f]", th)
+	}
+
+	//1種類の値では分けられない
+	th = otsu([]float64{0.3, 0.3, 0.3})
+	if th != 0 {
+		t.Errorf("otsu() single value threshold [%f]", th)
+	}
+}
+
+func TestAutoThreshold(t *testing.T) {
+
+	//背景との明度差が Brightness より小さい薄いインク
+	ink := color.RGBA{R: 190, G: 190, B: 190, A: 255}
+	img := createNote(120, 90, ink)
+
+	op := DefaultOption()
+	op.SamplingRate = 0.1
+	r, err := ShrinkResult(img, op)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+	if r.Labels[45*120+60] != 0 {
+		t.Errorf("ShrinkResult() fixed threshold detected ink")
+	}
+	if r.Brightness != op.Brightness || r.Saturation != op.Saturation {
+		t.Errorf("ShrinkResult() threshold [%f][%f]", r.Brightness, r.Saturation)
+	}
+
+	op.AutoThreshold = true
+	r, err = ShrinkResult(img, op)
+	if err != nil {
+		t.Fatalf("ShrinkResult() Error[%v]", err)
+	}
+	if r.Labels[45*120+60] == 0 {
+		t.Errorf("ShrinkResult() AutoThreshold not detected ink")
+	}
+	if r.Labels[0] != 0 {
+		t.Errorf("ShrinkResult() AutoThreshold background is foreground")
+	}
+
+	dv := 50.0 / 255.0
+	if r.Brightness < autoThresholdMin || r.Brightness > dv {
+		t.Errorf("ShrinkResult() AutoThreshold Brightness [%f]", r.Brightness)
+	}
+	if r.Saturation < autoThresholdMin || r.Saturation > autoThresholdMax {
+		t.Errorf("ShrinkResult() AutoThreshold Saturation [%f]", r.Saturation)
+	}
+	if op.Brightness != DefaultOption().Brightness {
+		t.Errorf("ShrinkResult() AutoThreshold changed Option")
+	}
+
+	//共通パレットでも同じ閾値を利用する
+	rs, err := ShrinkSet([]image.Image{img, img}, op)
+	if err != nil {
+		t.Fatalf("ShrinkSet() Error[%v]", err)
+	}
+	for idx, r := range rs {
+		if r.Labels[45*120+60] == 0 {
+			t.Errorf("ShrinkSet() AutoThreshold not detected ink[%d]", idx)
+		}
+	}
+}
+
 func BenchmarkShrink(b *testing.B) {
 	img, err := loadImage("sample/notesA1.jpg")
 	if err != nil {
@@ -89,6 +522,7 @@ func BenchmarkShrink(b *testing.B) {
 		return
 	}
 	op := DefaultOption()
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		shrink, err := Shrink(img, op)
@@ -111,6 +545,7 @@ func BenchmarkConvertPixels(b *testing.B) {
 		return
 	}
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		//データの展開
@@ -121,6 +556,30 @@ func BenchmarkConvertPixels(b *testing.B) {
 	}
 }
 
+//画素ごとにPixelを作成していた以前の展開との比較用
+func BenchmarkConvertPixelsLegacy(b *testing.B) {
+
+	img, err := loadImage("sample/notesA1.jpg")
+	if err != nil {
+		b.Errorf("loadImage() Error[%v]", err)
+		return
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rect := img.Bounds()
+		rtn := make(Pixels, rect.Dx()*rect.Dy())
+		idx := 0
+		for row := rect.Min.Y; row < rect.Max.Y; row++ {
+			for col := rect.Min.X; col < rect.Max.X; col++ {
+				rtn[idx] = NewPixel(img.At(col, row))
+				idx++
+			}
+		}
+	}
+}
+
 func BenchmarkCreateSample(b *testing.B) {
 
 	img, err := loadImage("sample/notesA1.jpg")
@@ -135,10 +594,11 @@ func BenchmarkCreateSample(b *testing.B) {
 
 	op := DefaultOption()
 	//サンプルの作成
-	num := int(float64(len(data)) * op.SamplingRate)
+	num := int(float64(data.len()) * op.SamplingRate)
+	rnd := newRand(op)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, err := createSample(data, num)
+		_, err := createSample(data, num, rnd)
 		if err != nil {
 			b.Errorf("createSample() Error[%v]", err)
 			return
@@ -163,8 +623,8 @@ func BenchmarkCreatePalette(b *testing.B) {
 	}
 
 	//サンプルの作成
-	num := int(float64(len(data)) * op.SamplingRate)
-	samples, err := createSample(data, num)
+	num := int(float64(data.len()) * op.SamplingRate)
+	samples, err := createSample(data, num, newRand(op))
 	if err != nil {
 		b.Errorf("createSample() Error[%v]", err)
 		return
@@ -172,8 +632,14 @@ func BenchmarkCreatePalette(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
+		//背景色を取得
+		bg, err := getBackgroundColor(samples, op)
+		if err != nil {
+			b.Errorf("getBackgroundColor() Error[%v]", err)
+			return
+		}
 		//色の選定
-		_, _, err := createPalette(samples, op)
+		_, err = createPalette(samples, nil, bg, op, newRand(op))
 		if err != nil {
 			b.Errorf("createPalette() Error[%v]", err)
 			return
@@ -182,13 +648,27 @@ func BenchmarkCreatePalette(b *testing.B) {
 }
 
 func BenchmarkApply(b *testing.B) {
+	benchmarkApply(b, DefaultOption())
+}
+
+//行で分割して並列に適用
+func BenchmarkApplyWorkers(b *testing.B) {
+	for _, w := range workerCounts(1, 2, 4) {
+		b.Run(fmt.Sprintf("Workers%d", w), func(b *testing.B) {
+			op := DefaultOption()
+			op.Workers = w
+			benchmarkApply(b, op)
+		})
+	}
+}
+
+func benchmarkApply(b *testing.B, op *Option) {
 
 	img, err := loadImage("sample/notesA1.jpg")
 	if err != nil {
 		b.Errorf("loadImage() Error[%v]", err)
 		return
 	}
-	op := DefaultOption()
 
 	//データの展開
 	data, err := convertPixels(img)
@@ -197,24 +677,32 @@ func BenchmarkApply(b *testing.B) {
 	}
 
 	//サンプルの作成
-	num := int(float64(len(data)) * op.SamplingRate)
-	samples, err := createSample(data, num)
+	num := int(float64(data.len()) * op.SamplingRate)
+	samples, err := createSample(data, num, newRand(op))
 	if err != nil {
 		b.Errorf("createSample() Error[%v]", err)
 		return
 	}
 
+	//背景色を取得
+	bg, err := getBackgroundColor(samples, op)
+	if err != nil {
+		b.Errorf("getBackgroundColor() Error[%v]", err)
+		return
+	}
+
 	//色の選定
-	bg, palette, err := createPalette(samples, op)
+	p, err := createPalette(samples, nil, bg, op, newRand(op))
 	if err != nil {
 		b.Errorf("createPalette() Error[%v]", err)
 		return
 	}
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		//色の適用
-		shrink, err := apply(data, bg, palette, op)
+		shrink, _, err := apply(data, nil, p.Background, p.Foreground, op)
 		if err != nil {
 			b.Errorf("apply() Error[%v]", err)
 			return
@@ -226,6 +714,56 @@ func BenchmarkApply(b *testing.B) {
 	}
 }
 
+//ベンチマークの goroutine の数(GOMAXPROCSを含め重複なし)
+func workerCounts(counts ...int) []int {
+	rtn := make([]int, 0, len(counts)+1)
+	for _, w := range append(counts, runtime.GOMAXPROCS(0)) {
+		dup := false
+		for _, r := range rtn {
+			dup = dup || r == w
+		}
+		if !dup {
+			rtn = append(rtn, w)
+		}
+	}
+	return rtn
+}
+
+//前景色の判定のみ
+func BenchmarkForegroundMaskWorkers(b *testing.B) {
+
+	img, err := loadImage("sample/notesA1.jpg")
+	if err != nil {
+		b.Errorf("loadImage() Error[%v]", err)
+		return
+	}
+	data, err := convertPixels(img)
+	if err != nil {
+		b.Errorf("convertPixels() Error[%v]", err)
+		return
+	}
+	bg := NewPixelRGB(224, 224, 224)
+
+	for _, mode := range []MaskMode{GlobalMask, AdaptiveMask} {
+		for _, w := range workerCounts(1) {
+			b.Run(fmt.Sprintf("%v/Workers%d", mode, w), func(b *testing.B) {
+				op := DefaultOption()
+				op.MaskMode = mode
+				op.Workers = w
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					_, err := getForegraundMask(data, bg, op)
+					if err != nil {
+						b.Errorf("getForegraundMask() Error[%v]", err)
+						return
+					}
+				}
+			})
+		}
+	}
+}
+
 func BenchmarkToImage(b *testing.B) {
 	img, err := loadImage("sample/notesA1.jpg")
 	if err != nil {
@@ -241,38 +779,50 @@ func BenchmarkToImage(b *testing.B) {
 	}
 
 	//サンプルの作成
-	num := int(float64(len(data)) * op.SamplingRate)
-	samples, err := createSample(data, num)
+	num := int(float64(data.len()) * op.SamplingRate)
+	samples, err := createSample(data, num, newRand(op))
 	if err != nil {
 		b.Errorf("createSample() Error[%v]", err)
 		return
 	}
 
+	//背景色を取得
+	bg, err := getBackgroundColor(samples, op)
+	if err != nil {
+		b.Errorf("getBackgroundColor() Error[%v]", err)
+		return
+	}
+
 	//色の選定
-	bg, palette, err := createPalette(samples, op)
+	p, err := createPalette(samples, nil, bg, op, newRand(op))
 	if err != nil {
 		b.Errorf("createPalett	e() Error[%v]", err)
 		return
 	}
 
 	//色の適用
-	shrink, err := apply(data, bg, palette, op)
+	labels, mask, err := apply(data, nil, p.Background, p.Foreground, op)
 	if err != nil {
 		b.Errorf("apply() Error[%v]", err)
 		return
 	}
-	if shrink == nil {
+	if labels == nil {
 		b.Errorf("image is nil")
 		return
 	}
 
 	rect := img.Bounds()
-	cols := rect.Dx()
-	rows := rect.Dy()
+	r := Result{
+		Palette: *p,
+		Labels:  labels,
+		Mask:    mask,
+		cols:    rect.Dx(),
+		rows:    rect.Dy(),
+	}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		shrink.ToImage(cols, rows)
+		r.toImage(r.Colors())
 	}
 }
 
@@ -293,7 +843,36 @@ func loadImage(f string) (image.Image, error) {
 }
 
 //Test用のツール
-func loadPixels(f string) (Pixels, error) {
+//背景(240,240,240)に中央付近を指定色で塗りつぶした画像を作成
+func createNote(cols, rows int, ink color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, cols, rows))
+	bg := color.RGBA{R: 240, G: 240, B: 240, A: 255}
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			c := bg
+			if x >= cols/4 && x < cols*3/4 && y >= rows/4 && y < rows*3/4 {
+				c = ink
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+//Test用のツール
+//全画素が異なる色の画像を作成
+func createGradation(cols, rows int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, cols, rows))
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x * 8), G: uint8(y * 8), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+//Test用のツール
+func loadPixels(f string) (*pixelBuffer, error) {
 	img, err := loadImage(f)
 	if err != nil {
 		return nil, err
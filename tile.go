@@ -0,0 +1,350 @@
+package noteshrink
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+//TileRows を指定しない場合の行数
+const defaultTileRows = 256
+
+//タイルに分けて圧縮し、PNGで書き込みます
+//
+//画像を TileRows 行ずつ展開する為、展開後のデータ、出力画像の全体を保持しません
+//サンプルは Shrink() と同じ位置から取得する為、同じオプションであれば同じ結果になります
+//画像全体が必要な Flatten,AdaptiveMask,OpenRadius,CloseRadius,MinArea は利用できません
+func ShrinkTiled(w io.Writer, img image.Image, op *Option) (*Palette, error) {
+
+	op, err := checkOption(op)
+	if err != nil {
+		return nil, err
+	}
+	err = checkTiled(op)
+	if err != nil {
+		return nil, err
+	}
+
+	rect := img.Bounds()
+	if rect.Empty() {
+		return nil, fmt.Errorf("image size zero")
+	}
+
+	//1回目:サンプルから色を選定
+	p, err := tiledPalette(img, op)
+	if err != nil {
+		return nil, err
+	}
+
+	//2回目:色を適用して書き込み
+	out := p.adjust(op)
+	pw, err := newPNGWriter(w, rect.Dx(), rect.Dy(), out.Colors())
+	if err != nil {
+		return nil, err
+	}
+
+	th := p.threshold(op)
+	m := newMatcher(p.Foreground, op.ColorSpace)
+	err = eachTile(img, op.TileRows, func(y0 int, tile *pixelBuffer) error {
+
+		flag, err := getForegraundMask(tile, p.Background, th)
+		if err != nil {
+			return err
+		}
+		labels := applyMatcher(tile, flag, m, th)
+
+		for y := 0; y < tile.rows; y++ {
+			err = pw.writeRow(labels[y*tile.cols : (y+1)*tile.cols])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = pw.close()
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+//タイルで処理できないオプションの確認
+func checkTiled(op *Option) error {
+
+	if op.Flatten {
+		return fmt.Errorf("Flatten not supported with ShrinkTiled")
+	}
+	if op.MaskMode != GlobalMask {
+		return fmt.Errorf("MaskMode not supported with ShrinkTiled[%v]", op.MaskMode)
+	}
+	if op.OpenRadius > 0 || op.CloseRadius > 0 || op.MinArea > 0 {
+		return fmt.Errorf("OpenRadius,CloseRadius,MinArea not supported with ShrinkTiled")
+	}
+	if op.TileRows < 0 {
+		return fmt.Errorf("TileRows must not be negative[%d]", op.TileRows)
+	}
+	return nil
+}
+
+//タイルごとにサンプルを集めてパレットを作成
+func tiledPalette(img image.Image, op *Option) (*Palette, error) {
+
+	rect := img.Bounds()
+	cols, rows := rect.Dx(), rect.Dy()
+
+	//Shrink() と同じ位置を位置順に取得
+	rnd := newRand(op)
+	num := int(float64(cols*rows) * op.SamplingRate)
+	index, err := createSampleIndex(cols*rows, num, rnd)
+	if err != nil {
+		return nil, err
+	}
+	order := make([]int, num)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return index[order[i]] < index[order[j]]
+	})
+
+	samples := newPixelBuffer(num, 1)
+	border := newPixelBuffer(0, 1)
+	k := 0
+	err = eachTile(img, op.TileRows, func(y0 int, tile *pixelBuffer) error {
+		start := y0 * cols
+		end := start + tile.len()
+		for ; k < num && index[order[k]] < end; k++ {
+			samples.pix[order[k]] = tile.pix[index[order[k]]-start]
+		}
+		if op.BackgroundMode == BorderSampling {
+			border.pix = appendBorder(border.pix, tile, y0, rows)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	//背景色を取得
+	bgp := samples
+	if op.BackgroundMode == BorderSampling {
+		border.cols = border.len()
+		bgp = border
+	}
+	bg, err := getBackgroundColor(bgp, op)
+	if err != nil {
+		return nil, err
+	}
+
+	//色の選定
+	return createPalette(samples, nil, bg, op, rnd)
+}
+
+//画像を行で分けて展開
+//
+//fn には画像の先頭からの行と、その行からの展開したデータを渡します
+func eachTile(img image.Image, tileRows int, fn func(y0 int, tile *pixelBuffer) error) error {
+
+	if tileRows <= 0 {
+		tileRows = defaultTileRows
+	}
+
+	rect := img.Bounds()
+	for y := rect.Min.Y; y < rect.Max.Y; y += tileRows {
+
+		r := image.Rect(rect.Min.X, y, rect.Max.X, minInt(y+tileRows, rect.Max.Y))
+		tile, err := convertPixels(subImage(img, r))
+		if err != nil {
+			return err
+		}
+
+		err = fn(y-rect.Min.Y, tile)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//画像の一部
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if s, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	return &boundedImage{Image: img, rect: r}
+}
+
+//SubImage() を持たない画像の範囲を制限します
+type boundedImage struct {
+	image.Image
+	rect image.Rectangle
+}
+
+func (b *boundedImage) Bounds() image.Rectangle {
+	return b.rect
+}
+
+//行ごとに書き込むインデックスカラーのPNG
+type pngWriter struct {
+	w    *bufio.Writer
+	idat *chunkWriter
+	zw   *zlib.Writer
+	cols int
+	bpc  int
+	line []byte
+}
+
+//PNGのシグネチャ
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+//ヘッダ(IHDR,PLTE)を書き込み
+func newPNGWriter(w io.Writer, cols, rows int, pal color.Palette) (*pngWriter, error) {
+
+	if len(pal) == 0 || len(pal) > 256 {
+		return nil, fmt.Errorf("palette length error[%d]", len(pal))
+	}
+
+	p := pngWriter{}
+	p.w = bufio.NewWriter(w)
+	p.cols = cols
+	p.bpc = bitsPerComponent(len(pal))
+	p.line = make([]byte, 1+(cols*p.bpc+7)/8)
+
+	_, err := io.WriteString(p.w, pngHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(cols))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(rows))
+	ihdr[8] = uint8(p.bpc)
+	ihdr[9] = 3 //インデックスカラー
+	err = writeChunk(p.w, "IHDR", ihdr)
+	if err != nil {
+		return nil, err
+	}
+
+	plte := make([]byte, 0, len(pal)*3)
+	for _, c := range pal {
+		r, g, b, _ := c.RGBA()
+		plte = append(plte, uint8(r>>8), uint8(g>>8), uint8(b>>8))
+	}
+	err = writeChunk(p.w, "PLTE", plte)
+	if err != nil {
+		return nil, err
+	}
+
+	p.idat = &chunkWriter{w: p.w, name: "IDAT"}
+	p.zw, err = zlib.NewWriterLevel(p.idat, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+//1行分のインデックスを書き込み
+func (p *pngWriter) writeRow(pix []uint8) error {
+
+	if len(pix) != p.cols {
+		return fmt.Errorf("row length error[%d]!=[%d]", len(pix), p.cols)
+	}
+
+	//フィルタなし
+	for i := range p.line {
+		p.line[i] = 0
+	}
+	for col, v := range pix {
+		bit := col * p.bpc
+		p.line[1+bit/8] |= v << uint(8-p.bpc-bit%8)
+	}
+	_, err := p.zw.Write(p.line)
+	return err
+}
+
+//残りのデータとIENDを書き込み
+func (p *pngWriter) close() error {
+
+	err := p.zw.Close()
+	if err != nil {
+		return err
+	}
+	err = p.idat.flush()
+	if err != nil {
+		return err
+	}
+	err = writeChunk(p.w, "IEND", nil)
+	if err != nil {
+		return err
+	}
+	return p.w.Flush()
+}
+
+//書き込んだデータを一定の大きさごとにチャンクにします
+type chunkWriter struct {
+	w    io.Writer
+	name string
+	buf  []byte
+}
+
+//チャンクの最大の大きさ
+const chunkSize = 1 << 16
+
+func (c *chunkWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		l := minInt(chunkSize-len(c.buf), len(b))
+		c.buf = append(c.buf, b[:l]...)
+		b = b[l:]
+		if len(c.buf) == chunkSize {
+			err := c.flush()
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (c *chunkWriter) flush() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	err := writeChunk(c.w, c.name, c.buf)
+	c.buf = c.buf[:0]
+	return err
+}
+
+//チャンク(長さ,種類,データ,CRC)の書き込み
+func writeChunk(w io.Writer, name string, data []byte) error {
+
+	head := make([]byte, 8)
+	binary.BigEndian.PutUint32(head, uint32(len(data)))
+	copy(head[4:], name)
+
+	crc := crc32.NewIEEE()
+	crc.Write(head[4:])
+	crc.Write(data)
+	tail := make([]byte, 4)
+	binary.BigEndian.PutUint32(tail, crc.Sum32())
+
+	for _, b := range [][]byte{head, data, tail} {
+		_, err := w.Write(b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package noteshrink
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+func TestShrinkTiled(t *testing.T) {
+
+	rnd := rand.New(rand.NewSource(7))
+	img := createNote(123, 77, color.RGBA{R: 20, G: 40, B: 200, A: 255})
+	for i := 0; i < 300; i++ {
+		img.SetRGBA(rnd.Intn(123), rnd.Intn(77), color.RGBA{R: uint8(rnd.Intn(256)), G: 20, B: 20, A: 255})
+	}
+
+	options := map[string]func(op *Option){
+		"default": func(op *Option) {},
+		"border":  func(op *Option) { op.BackgroundMode = BorderSampling },
+		"auto":    func(op *Option) { op.AutoThreshold = true },
+		"white":   func(op *Option) { op.WhiteBackground = true; op.Saturate = true },
+		"two":     func(op *Option) { op.ForegroundNum = 2 },
+		"oklab":   func(op *Option) { op.ColorSpace = OKLab; op.Workers = 3 },
+	}
+
+	for name, set := range options {
+
+		op := DefaultOption()
+		op.SamplingRate = 0.05
+		set(op)
+
+		expected, err := ShrinkResult(img, op)
+		if err != nil {
+			t.Fatalf("ShrinkResult() %s Error[%v]", name, err)
+		}
+
+		for _, rows := range []int{0, 1, 10, 77, 100} {
+			op.TileRows = rows
+			var buf bytes.Buffer
+			p, err := ShrinkTiled(&buf, img, op)
+			if err != nil {
+				t.Fatalf("ShrinkTiled() %s[%d] Error[%v]", name, rows, err)
+			}
+			if len(p.Foreground) != len(expected.Foreground) {
+				t.Errorf("ShrinkTiled() %s[%d] palette not same", name, rows)
+			}
+
+			out, err := png.Decode(&buf)
+			if err != nil {
+				t.Fatalf("png.Decode() %s[%d] Error[%v]", name, rows, err)
+			}
+			compareImage(t, name, expected.Image, out)
+		}
+	}
+}
+
+func TestShrinkTiledOffset(t *testing.T) {
+
+	//原点が0でない画像、SubImage() を持たない画像
+	src := createNote(100, 80, color.RGBA{R: 200, G: 30, B: 30, A: 255})
+	sub := src.SubImage(image.Rect(10, 5, 90, 75))
+	wrap := &boundedImage{Image: sub, rect: sub.Bounds()}
+
+	op := DefaultOption()
+	op.SamplingRate = 0.05
+	op.TileRows = 16
+	expected, err := Shrink(sub, op)
+	if err != nil {
+		t.Fatalf("Shrink() Error[%v]", err)
+	}
+
+	for _, img := range []image.Image{sub, wrap} {
+		var buf bytes.Buffer
+		_, err := ShrinkTiled(&buf, img, op)
+		if err != nil {
+			t.Fatalf("ShrinkTiled() Error[%v]", err)
+		}
+		out, err := png.Decode(&buf)
+		if err != nil {
+			t.Fatalf("png.Decode() Error[%v]", err)
+		}
+		compareImage(t, "offset", expected, out)
+	}
+}
+
+func TestShrinkTiledOption(t *testing.T) {
+
+	img := createNote(40, 30, color.RGBA{R: 20, G: 40, B: 200, A: 255})
+
+	options := map[string]func(op *Option){
+		"flatten":  func(op *Option) { op.Flatten = true },
+		"adaptive": func(op *Option) { op.MaskMode = AdaptiveMask },
+		"minarea":  func(op *Option) { op.MinArea = 4 },
+		"open":     func(op *Option) { op.OpenRadius = 1 },
+		"tile":     func(op *Option) { op.TileRows = -1 },
+	}
+
+	for name, set := range options {
+		op := DefaultOption()
+		set(op)
+		var buf bytes.Buffer
+		_, err := ShrinkTiled(&buf, img, op)
+		if err == nil {
+			t.Errorf("ShrinkTiled() %s not error", name)
+		}
+	}
+}
+
+func TestChunkWriter(t *testing.T) {
+
+	var buf bytes.Buffer
+	c := &chunkWriter{w: &buf, name: "IDAT"}
+	data := make([]byte, chunkSize*2+10)
+	for i := range data {
+		data[i] = uint8(i)
+	}
+
+	n, err := c.Write(data[:100])
+	if err != nil || n != 100 {
+		t.Fatalf("chunkWriter.Write() [%d] Error[%v]", n, err)
+	}
+	_, err = c.Write(data[100:])
+	if err != nil {
+		t.Fatalf("chunkWriter.Write() Error[%v]", err)
+	}
+	err = c.flush()
+	if err != nil {
+		t.Fatalf("chunkWriter.flush() Error[%v]", err)
+	}
+
+	//長さ,種類,CRCの12バイトが3チャンク
+	if buf.Len() != len(data)+12*3 {
+		t.Errorf("chunkWriter length [%d]", buf.Len())
+	}
+}
+
+//Test用のツール
+//インデックスではなく色で比較
+func compareImage(t *testing.T, name string, expected, out image.Image) {
+
+	t.Helper()
+	if expected.Bounds().Size() != out.Bounds().Size() {
+		t.Fatalf("%s size not same [%v]!=[%v]", name, out.Bounds(), expected.Bounds())
+	}
+
+	er := expected.Bounds()
+	or := out.Bounds()
+	for y := 0; y < er.Dy(); y++ {
+		for x := 0; x < er.Dx(); x++ {
+			c1 := color.RGBAModel.Convert(expected.At(er.Min.X+x, er.Min.Y+y))
+			c2 := color.RGBAModel.Convert(out.At(or.Min.X+x, or.Min.Y+y))
+			if c1 != c2 {
+				t.Fatalf("%s [%d,%d] color not same [%v]!=[%v]", name, x, y, c2, c1)
+			}
+		}
+	}
+}